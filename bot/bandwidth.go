@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimits of the media downloads in bytes per second. Zero means no limit
+type BandwidthLimits struct {
+	// shared by all the jobs
+	Global int64
+	// each job gets its own budget
+	PerJob int64
+}
+
+// a token bucket of bytes with a second worth of burst
+func newByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+type jobLimiterKey struct{}
+
+// JobContext returns the context carrying the bandwidth budget of a job.
+// Downloads with the same job context share it
+func (d *Downloader) JobContext(ctx context.Context) context.Context {
+	if d.bandwidth.PerJob <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, jobLimiterKey{}, newByteLimiter(d.bandwidth.PerJob))
+}
+
+// limitReader throttles the reader with the global and the job limiters
+func (d *Downloader) limitReader(ctx context.Context, r io.Reader) io.Reader {
+	var limiters []*rate.Limiter
+
+	if d.globalBandwidth != nil {
+		limiters = append(limiters, d.globalBandwidth)
+	}
+
+	if l, ok := ctx.Value(jobLimiterKey{}).(*rate.Limiter); ok {
+		limiters = append(limiters, l)
+	}
+
+	if len(limiters) == 0 {
+		return r
+	}
+
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// WaitN fails for more than the burst
+	for _, limiter := range l.limiters {
+		if len(p) > limiter.Burst() {
+			p = p[:limiter.Burst()]
+		}
+	}
+
+	n, err := l.r.Read(p)
+
+	if n <= 0 {
+		return n, err
+	}
+
+	for _, limiter := range l.limiters {
+		if waitErr := limiter.WaitN(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimits(t *testing.T) {
+	d := NewDownloader(WithBandwidthLimits(BandwidthLimits{PerJob: 10000}))
+	data := bytes.Repeat([]byte("x"), 15000)
+
+	// no job context, no limit
+	start := time.Now()
+	n, err := io.Copy(io.Discard, d.limitReader(context.Background(), bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), n)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// a second worth of burst, the rest at the limit
+	ctx := d.JobContext(context.Background())
+
+	start = time.Now()
+	n, err = io.Copy(io.Discard, d.limitReader(ctx, bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), n)
+	require.Greater(t, time.Since(start), 400*time.Millisecond)
+
+	// the job budget is spent
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	_, err = io.Copy(io.Discard, d.limitReader(ctx, bytes.NewReader(data)))
+	require.Error(t, err)
+}
@@ -0,0 +1,441 @@
+package bot
+
+import (
+	"bytes"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-faster/errors"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// CardTheme is the colors of a tweet card
+type CardTheme struct {
+	Name       string
+	Background color.RGBA
+	Text       color.RGBA
+	// handle, date and emoji placeholders
+	Secondary color.RGBA
+	// quoted tweet box
+	Border color.RGBA
+}
+
+// the colors of X
+var (
+	CardThemeLight = CardTheme{
+		Name:       "light",
+		Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Text:       color.RGBA{0x0f, 0x14, 0x19, 0xff},
+		Secondary:  color.RGBA{0x53, 0x64, 0x71, 0xff},
+		Border:     color.RGBA{0xcf, 0xd9, 0xde, 0xff},
+	}
+	CardThemeDark = CardTheme{
+		Name:       "dark",
+		Background: color.RGBA{0x00, 0x00, 0x00, 0xff},
+		Text:       color.RGBA{0xe7, 0xe9, 0xea, 0xff},
+		Secondary:  color.RGBA{0x71, 0x76, 0x7b, 0xff},
+		Border:     color.RGBA{0x2f, 0x33, 0x36, 0xff},
+	}
+)
+
+// ParseCardTheme returns the theme by its name, light or dark
+func ParseCardTheme(name string) (CardTheme, error) {
+	switch name {
+	case CardThemeLight.Name:
+		return CardThemeLight, nil
+	case CardThemeDark.Name:
+		return CardThemeDark, nil
+	}
+	return CardTheme{}, errors.Errorf("unknown card theme %q", name)
+}
+
+// CardOptions sets the size of the tweet cards
+type CardOptions struct {
+	Width int
+	// longer texts are cut
+	MaxLines int
+}
+
+func DefaultCardOptions() CardOptions {
+	return CardOptions{
+		Width:    1080,
+		MaxLines: 30,
+	}
+}
+
+// lines of the quoted tweet text
+const cardQuotedMaxLines = 8
+
+// drawn instead of the glyphs missing from the fonts like emoji
+const cardPlaceholder = '\ue000'
+
+type cardFonts struct {
+	regular *sfnt.Font
+	bold    *sfnt.Font
+}
+
+// the embedded Go fonts
+var loadCardFonts = sync.OnceValues(func() (*cardFonts, error) {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse regular font")
+	}
+
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse bold font")
+	}
+
+	return &cardFonts{regular: regular, bold: bold}, nil
+})
+
+// a font of a size. Faces are not safe for concurrent use so they are made per card
+type cardFace struct {
+	font *sfnt.Font
+	face font.Face
+	size float64
+	buf  sfnt.Buffer
+}
+
+func newCardFace(f *sfnt.Font, size float64) (*cardFace, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, errors.Wrap(err, "new face")
+	}
+	return &cardFace{font: f, face: face, size: size}, nil
+}
+
+func (f *cardFace) has(r rune) bool {
+	i, err := f.font.GlyphIndex(&f.buf, r)
+	return err == nil && i != 0
+}
+
+func (f *cardFace) lineHeight() int {
+	return int(f.size * 1.35)
+}
+
+// zero width runes of emoji sequences
+func isEmojiJoiner(r rune) bool {
+	return r == '\u200d' || (r >= '\ufe00' && r <= '\ufe0f') ||
+		(r >= 0x1f3fb && r <= 0x1f3ff) || (r >= 0xe0020 && r <= 0xe007f)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// replaces the sequences of runes missing from the font with one placeholder each,
+// so a family emoji or a flag is one placeholder
+func (f *cardFace) fold(s string) string {
+	var sb strings.Builder
+
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if r == '\n' || r == '\t' || f.has(r) {
+			if r == '\t' {
+				r = ' '
+			}
+			sb.WriteRune(r)
+			continue
+		}
+
+		if isEmojiJoiner(r) || !unicode.IsGraphic(r) {
+			continue
+		}
+
+		sb.WriteRune(cardPlaceholder)
+
+		// the flag is a pair of regional indicators
+		if isRegionalIndicator(r) && i+1 < len(runes) && isRegionalIndicator(runes[i+1]) {
+			i++
+		}
+
+		// modifiers and the emoji joined by zwj
+		for i+1 < len(runes) && isEmojiJoiner(runes[i+1]) {
+			i++
+			if runes[i] == '\u200d' && i+1 < len(runes) {
+				i++
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// width of the folded text
+func (f *cardFace) width(s string) int {
+	var w fixed.Int26_6
+
+	for i, part := range strings.Split(s, string(cardPlaceholder)) {
+		if i > 0 {
+			w += fixed.I(int(f.size))
+		}
+		w += font.MeasureString(f.face, part)
+	}
+
+	return w.Ceil()
+}
+
+// draws the folded text with the baseline at y
+func (f *cardFace) draw(dst draw.Image, x, y int, s string, c, placeholder color.Color) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: f.face, Dot: fixed.P(x, y)}
+
+	for i, part := range strings.Split(s, string(cardPlaceholder)) {
+		if i > 0 {
+			// a dot in place of the emoji
+			side := int(f.size)
+			r := side * 2 / 5
+			center := image.Pt(d.Dot.X.Round()+side/2, y-int(f.size*0.35))
+			fillRoundedRect(dst, image.Rect(center.X-r, center.Y-r, center.X+r, center.Y+r), r, placeholder)
+			d.Dot.X += fixed.I(side)
+		}
+		d.DrawString(part)
+	}
+}
+
+// cuts the line so it fits with the ellipsis
+func (f *cardFace) ellipsis(line string, width int) string {
+	runes := []rune(strings.TrimRight(line, " "))
+
+	for len(runes) > 0 && f.width(string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	return strings.TrimRight(string(runes), " ") + "…"
+}
+
+// wraps the text by words to the width. Words longer than the width are broken
+func (f *cardFace) wrap(text string, width, maxLines int) []string {
+	var lines []string
+
+	cut := false
+
+	for _, paragraph := range strings.Split(f.fold(text), "\n") {
+		line := ""
+
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+
+			if f.width(candidate) <= width {
+				line = candidate
+				continue
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			line = word
+
+			for f.width(line) > width {
+				runes := []rune(line)
+				n := len(runes) - 1
+				for n > 1 && f.width(string(runes[:n])) > width {
+					n--
+				}
+				// at least a rune per line if the width fits none
+				n = max(n, 1)
+				lines = append(lines, string(runes[:n]))
+				line = string(runes[n:])
+			}
+		}
+
+		lines = append(lines, line)
+
+		if len(lines) > maxLines {
+			cut = true
+			break
+		}
+	}
+
+	// no empty lines at the end
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) > maxLines {
+		lines, cut = lines[:maxLines], true
+	}
+
+	if cut && len(lines) > 0 {
+		lines[len(lines)-1] = f.ellipsis(lines[len(lines)-1], width)
+	}
+
+	return lines
+}
+
+// draws the lines from the top. Returns the bottom
+func (f *cardFace) drawLines(dst draw.Image, x, top int, lines []string, c, placeholder color.Color) int {
+	m := f.face.Metrics()
+	lh := f.lineHeight()
+	baseline := m.Ascent.Ceil() + (lh-m.Height.Ceil())/2
+
+	for _, line := range lines {
+		f.draw(dst, x, top+baseline, line, c, placeholder)
+		top += lh
+	}
+
+	return top
+}
+
+func fillRoundedRect(dst draw.Image, r image.Rectangle, radius int, c color.Color) {
+	radius = min(radius, r.Dx()/2, r.Dy()/2)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// the distance to the center of the corner circle
+			dx := max(r.Min.X+radius-x-1, x-(r.Max.X-radius), 0)
+			dy := max(r.Min.Y+radius-y-1, y-(r.Max.Y-radius), 0)
+
+			if dx*dx+dy*dy <= radius*radius {
+				dst.Set(x, y, c)
+			}
+		}
+	}
+}
+
+func tweetAuthor(td *twitter.TweetData) (string, string) {
+	handle := td.Author()
+
+	name := td.AuthorName
+	if name == "" {
+		name = handle
+	}
+
+	return name, "@" + handle
+}
+
+func tweetTime(td *twitter.TweetData) time.Time {
+	if !td.CreatedAt.IsZero() {
+		return td.CreatedAt
+	}
+	t, _ := twitter.SnowflakeTime(td.Url.ID)
+	return t
+}
+
+func cardText(td *twitter.TweetData) string {
+	return strings.TrimSpace(html.UnescapeString(td.TweetText()))
+}
+
+// RenderCard draws the tweet as an image like X shows it: the author, the date,
+// the text and the quoted tweet in a box. Glyphs missing from the Go fonts like
+// emoji are drawn as dots
+func RenderCard(td *twitter.TweetData, theme CardTheme, opts CardOptions) (image.Image, error) {
+	fonts, err := loadCardFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	scale := float64(opts.Width) / 1080
+	px := func(v float64) int { return int(v * scale) }
+
+	faces := make([]*cardFace, 5)
+
+	for i, spec := range []struct {
+		font *sfnt.Font
+		size float64
+	}{
+		{fonts.bold, 34}, {fonts.regular, 28}, {fonts.regular, 40}, {fonts.bold, 28}, {fonts.regular, 32},
+	} {
+		if faces[i], err = newCardFace(spec.font, spec.size*scale); err != nil {
+			return nil, err
+		}
+	}
+
+	nameFace, metaFace, textFace, quotedNameFace, quotedTextFace := faces[0], faces[1], faces[2], faces[3], faces[4]
+
+	pad := px(48)
+	width := opts.Width - 2*pad
+
+	name, handle := tweetAuthor(td)
+	meta := handle
+
+	if t := tweetTime(td); !t.IsZero() {
+		meta += " · " + t.UTC().Format("15:04 · Jan 2, 2006")
+	}
+
+	nameLines := nameFace.wrap(name, width, 1)
+	metaLines := metaFace.wrap(meta, width, 1)
+	textLines := textFace.wrap(cardText(td), width, opts.MaxLines)
+
+	height := pad + len(nameLines)*nameFace.lineHeight() + len(metaLines)*metaFace.lineHeight()
+
+	if len(textLines) > 0 {
+		height += px(24) + len(textLines)*textFace.lineHeight()
+	}
+
+	var quotedHeader, quotedLines []string
+
+	quotedPad := px(24)
+	quotedTop := 0
+
+	if q := td.Quoted; q != nil {
+		qName, qHandle := tweetAuthor(q)
+		quotedHeader = quotedNameFace.wrap(qName+" "+qHandle, width-2*quotedPad, 1)
+		quotedLines = quotedTextFace.wrap(cardText(q), width-2*quotedPad, cardQuotedMaxLines)
+
+		quotedTop = height + px(32)
+		height = quotedTop + 2*quotedPad + len(quotedHeader)*quotedNameFace.lineHeight() +
+			len(quotedLines)*quotedTextFace.lineHeight()
+	}
+
+	height += pad
+
+	dst := image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(theme.Background), image.Point{}, draw.Src)
+
+	y := nameFace.drawLines(dst, pad, pad, nameLines, theme.Text, theme.Secondary)
+	y = metaFace.drawLines(dst, pad, y, metaLines, theme.Secondary, theme.Secondary)
+
+	if len(textLines) > 0 {
+		textFace.drawLines(dst, pad, y+px(24), textLines, theme.Text, theme.Secondary)
+	}
+
+	if td.Quoted != nil {
+		box := image.Rect(pad, quotedTop, opts.Width-pad, height-pad)
+		border := max(1, px(2))
+
+		fillRoundedRect(dst, box, px(16), theme.Border)
+		fillRoundedRect(dst, box.Inset(border), px(16)-border, theme.Background)
+
+		y := quotedNameFace.drawLines(dst, pad+quotedPad, quotedTop+quotedPad, quotedHeader, theme.Text, theme.Secondary)
+		quotedTextFace.drawLines(dst, pad+quotedPad, y, quotedLines, theme.Text, theme.Secondary)
+	}
+
+	return dst, nil
+}
+
+// RenderCardPNG renders the tweet card as png
+func RenderCardPNG(td *twitter.TweetData, theme CardTheme, opts CardOptions) ([]byte, error) {
+	img, err := RenderCard(td, theme, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, errors.Wrap(err, "encode png")
+	}
+
+	return buf.Bytes(), nil
+}
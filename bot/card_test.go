@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardFold(t *testing.T) {
+	fonts, err := loadCardFonts()
+	require.NoError(t, err)
+
+	face, err := newCardFace(fonts.regular, 40)
+	require.NoError(t, err)
+
+	// a family, a flag and a thumb with the skin tone are one placeholder each
+	p := string(cardPlaceholder)
+	require.Equal(t, "Привет "+p+" "+p+" "+p+"!", face.fold("Привет 👨‍👩‍👧 🇺🇸 👍🏽!"))
+	require.Equal(t, "a\nb c", face.fold("a\nb\tc"))
+
+	lines := face.wrap(strings.Repeat("word ", 200), 400, 3)
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasSuffix(lines[2], "…"))
+
+	for _, line := range lines {
+		require.LessOrEqual(t, face.width(line), 400)
+	}
+
+	// long words are broken
+	lines = face.wrap(strings.Repeat("x", 100), 400, 10)
+	require.Greater(t, len(lines), 1)
+
+	// no width for even a rune
+	lines = face.wrap("xyz", 0, 10)
+	require.Equal(t, []string{"x", "y", "z"}, lines)
+}
+
+func TestRenderCard(t *testing.T) {
+	td := &twitter.TweetData{
+		Url:              twitter.TwitterURL{User: "author", ID: "1742878545549087076"},
+		FullText:         "Hello &amp; welcome 🎉\n\nsecond paragraph",
+		AuthorName:       "Author",
+		AuthorScreenName: "author",
+		CreatedAt:        time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC),
+	}
+
+	opts := DefaultCardOptions()
+
+	img, err := RenderCard(td, CardThemeLight, opts)
+	require.NoError(t, err)
+	require.Equal(t, opts.Width, img.Bounds().Dx())
+
+	td.Quoted = &twitter.TweetData{Url: twitter.TwitterURL{User: "quoted"}, FullText: "the quoted tweet"}
+
+	data, err := RenderCardPNG(td, CardThemeDark, opts)
+	require.NoError(t, err)
+
+	quoted, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Greater(t, quoted.Bounds().Dy(), img.Bounds().Dy())
+
+	// the background of the theme
+	r, g, b, _ := quoted.At(0, 0).RGBA()
+	require.Equal(t, [3]uint32{0, 0, 0}, [3]uint32{r, g, b})
+
+	_, err = ParseCardTheme("sepia")
+	require.Error(t, err)
+}
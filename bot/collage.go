@@ -0,0 +1,197 @@
+package bot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+
+	"github.com/go-faster/errors"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"golang.org/x/image/draw"
+)
+
+// telegram limit of photos
+const telegramPhotoMaxSize = 10 << 20
+
+// CollageOptions sets how the photos of a tweet are joined into one image
+type CollageOptions struct {
+	// space between the photos in pixels
+	Gutter int
+	// limit of the longer side of the collage
+	MaxSide int
+	// limit of the encoded jpeg
+	MaxSize int64
+}
+
+func DefaultCollageOptions() CollageOptions {
+	return CollageOptions{
+		Gutter:  8,
+		MaxSide: 2560,
+		MaxSize: telegramPhotoMaxSize,
+	}
+}
+
+// canCollage is true for tweets with 2 to 4 photos and no videos
+func canCollage(td *twitter.TweetData) bool {
+	return len(td.Videos) == 0 && len(td.Photos) >= 2 && len(td.Photos) <= 4
+}
+
+// places images of the sizes in a row of the width keeping their aspect ratios.
+// Returns the height of the row
+func layoutRow(rects []image.Rectangle, sizes []image.Point, x, y, width, gutter float64) float64 {
+	var aspects float64
+	for _, s := range sizes {
+		aspects += float64(s.X) / float64(s.Y)
+	}
+
+	h := (width - gutter*float64(len(sizes)-1)) / aspects
+
+	for i, s := range sizes {
+		w := h * float64(s.X) / float64(s.Y)
+		rects[i] = roundRect(x, y, x+w, y+h)
+		x += w + gutter
+	}
+
+	return h
+}
+
+// places images of the sizes in a column of the width. Returns the height of the column
+func layoutColumn(rects []image.Rectangle, sizes []image.Point, x, y, width, gutter float64) float64 {
+	top := y
+
+	for i, s := range sizes {
+		h := width * float64(s.Y) / float64(s.X)
+		rects[i] = roundRect(x, y, x+width, y+h)
+		y += h + gutter
+	}
+
+	return y - gutter - top
+}
+
+func roundRect(x0, y0, x1, y1 float64) image.Rectangle {
+	return image.Rect(int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x1)), int(math.Round(y1)))
+}
+
+// collageLayout places 2 to 4 images of the sizes the way X shows them: two side
+// by side, three as one on the left and two stacked on the right, four in two rows.
+// The aspect ratios are kept so the rows and the columns get the heights and the
+// widths they need. Returns the rectangles of the images and the collage size
+func collageLayout(sizes []image.Point, width, gutter int) ([]image.Rectangle, image.Point) {
+	rects := make([]image.Rectangle, len(sizes))
+	w, g := float64(width), float64(gutter)
+
+	var h float64
+
+	switch len(sizes) {
+	case 3:
+		// the left photo is as high as the right column
+		left := float64(sizes[0].X) / float64(sizes[0].Y)
+		inv := float64(sizes[1].Y)/float64(sizes[1].X) + float64(sizes[2].Y)/float64(sizes[2].X)
+
+		h = (w - g + g/inv) / (left + 1/inv)
+		leftWidth := left * h
+
+		rects[0] = roundRect(0, 0, leftWidth, h)
+		layoutColumn(rects[1:], sizes[1:], leftWidth+g, 0, w-leftWidth-g, g)
+	case 4:
+		h = layoutRow(rects[:2], sizes[:2], 0, 0, w, g) + g
+		h += layoutRow(rects[2:], sizes[2:], 0, h, w, g)
+	default:
+		h = layoutRow(rects, sizes, 0, 0, w, g)
+	}
+
+	return rects, image.Pt(width, int(math.Round(h)))
+}
+
+// fits the collage into the longer side
+func fitCollage(sizes []image.Point, maxSide, gutter int) ([]image.Rectangle, image.Point) {
+	rects, size := collageLayout(sizes, maxSide, gutter)
+
+	// the height is almost linear in the width so two passes are enough
+	for i := 0; i < 2 && size.Y > maxSide; i++ {
+		rects, size = collageLayout(sizes, max(1, size.X*maxSide/size.Y), gutter)
+	}
+
+	return rects, size
+}
+
+func drawCollage(images []image.Image, maxSide, gutter int) *image.RGBA {
+	sizes := make([]image.Point, len(images))
+	for i, img := range images {
+		sizes[i] = img.Bounds().Size()
+	}
+
+	rects, size := fitCollage(sizes, maxSide, gutter)
+
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	for i, img := range images {
+		draw.CatmullRom.Scale(dst, rects[i].Intersect(dst.Bounds()), img, img.Bounds(), draw.Src, nil)
+	}
+
+	return dst
+}
+
+// MakeCollage joins the images into one jpeg under the size limit. The quality
+// is lowered first and then the collage is made smaller
+func MakeCollage(images []image.Image, opts CollageOptions) ([]byte, error) {
+	if len(images) < 2 || len(images) > 4 {
+		return nil, errors.Errorf("collage of %d images", len(images))
+	}
+
+	for _, img := range images {
+		if img.Bounds().Empty() {
+			return nil, errors.New("empty image")
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for side := opts.MaxSide; side >= 256; side = side * 3 / 4 {
+		dst := drawCollage(images, side, opts.Gutter)
+
+		for quality := 90; quality >= 50; quality -= 10 {
+			buf.Reset()
+
+			if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, errors.Wrap(err, "encode jpeg")
+			}
+
+			if opts.MaxSize <= 0 || int64(buf.Len()) <= opts.MaxSize {
+				return buf.Bytes(), nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf("collage is %d bytes", buf.Len())
+}
+
+// makeCollageFile writes the collage of the image files to dst
+func makeCollageFile(paths []string, dst string, opts CollageOptions) error {
+	images := make([]image.Image, len(paths))
+
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return errors.Wrap(err, "open image")
+		}
+
+		images[i], _, err = image.Decode(f)
+		f.Close()
+
+		if err != nil {
+			return errors.Wrapf(err, "decode %s", p)
+		}
+	}
+
+	data, err := MakeCollage(images, opts)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, data, 0644)
+}
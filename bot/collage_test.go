@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollageLayout(t *testing.T) {
+	square := image.Pt(500, 500)
+
+	rects, size := collageLayout([]image.Point{square, image.Pt(1000, 500)}, 300, 0)
+	require.Equal(t, image.Pt(300, 100), size)
+	require.Equal(t, []image.Rectangle{image.Rect(0, 0, 100, 100), image.Rect(100, 0, 300, 100)}, rects)
+
+	// one on the left as high as the two on the right
+	rects, size = collageLayout([]image.Point{square, square, square}, 300, 0)
+	require.Equal(t, image.Pt(300, 200), size)
+	require.Equal(t, []image.Rectangle{
+		image.Rect(0, 0, 200, 200),
+		image.Rect(200, 0, 300, 100),
+		image.Rect(200, 100, 300, 200),
+	}, rects)
+
+	rects, size = collageLayout([]image.Point{square, square, square, square}, 210, 10)
+	require.Equal(t, image.Pt(210, 210), size)
+	require.Equal(t, []image.Rectangle{
+		image.Rect(0, 0, 100, 100),
+		image.Rect(110, 0, 210, 100),
+		image.Rect(0, 110, 100, 210),
+		image.Rect(110, 110, 210, 210),
+	}, rects)
+
+	// tall images are fitted by the height
+	_, size = fitCollage([]image.Point{image.Pt(100, 1000), image.Pt(100, 1000)}, 1000, 0)
+	require.Equal(t, image.Pt(200, 1000), size)
+}
+
+func TestMakeCollage(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	// noise compresses badly
+	noise := func(w, h int) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(rnd.Intn(256))
+		}
+		return img
+	}
+
+	opts := CollageOptions{Gutter: 4, MaxSide: 800, MaxSize: 100 << 10}
+
+	data, err := MakeCollage([]image.Image{noise(400, 300), noise(300, 400)}, opts)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(data), 100<<10)
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.LessOrEqual(t, max(img.Bounds().Dx(), img.Bounds().Dy()), 800)
+
+	_, err = MakeCollage([]image.Image{noise(10, 10)}, opts)
+	require.Error(t, err)
+}
@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// folder of the content store objects in the download folder
+const contentDirName = ".objects"
+
+// ContentObject is a media stored by the sha256 of its content
+type ContentObject struct {
+	Hash string `json:"hash"`
+	// extension with the dot
+	Ext  string `json:"ext"`
+	Size int64  `json:"size"`
+}
+
+// ContentStore keeps each media once as objects/ab/abcd...ext and hard links it
+// to the paths of the requests. The index maps tweet id and media key to the object
+// so media already downloaded are not downloaded again
+type ContentStore struct {
+	Dir       string
+	IndexFile string
+	index     map[string]ContentObject
+	mutex     *sync.Mutex
+}
+
+func NewContentStore(dir, indexFile string) (*ContentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create objects folder")
+	}
+
+	s := &ContentStore{
+		Dir:       dir,
+		IndexFile: indexFile,
+		index:     make(map[string]ContentObject),
+		mutex:     &sync.Mutex{},
+	}
+
+	data, err := os.ReadFile(indexFile)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read content index")
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &s.index); err != nil {
+			return nil, errors.Wrap(err, "unmarshal content index")
+		}
+	}
+
+	return s, nil
+}
+
+func (s *ContentStore) objectPath(o ContentObject) string {
+	return filepath.Join(s.Dir, o.Hash[:2], o.Hash+o.Ext)
+}
+
+func (s *ContentStore) saveLocked() error {
+	data, err := json.Marshal(s.index)
+
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	return writeFileAtomic(s.IndexFile, data, 0644)
+}
+
+// Lookup returns the object of the media if it is still stored
+func (s *ContentStore) Lookup(tweetID, mediaKey string) (ContentObject, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := fileRefKey(tweetID, mediaKey)
+	o, ok := s.index[key]
+
+	if !ok {
+		return o, false
+	}
+
+	// objects are removed by the sweeper
+	if _, err := os.Stat(s.objectPath(o)); err != nil {
+		delete(s.index, key)
+		_ = s.saveLocked()
+		return o, false
+	}
+
+	return o, true
+}
+
+// Link makes the path a hard link to the object. The file is copied if linking is not supported
+func (s *ContentStore) Link(o ContentObject, path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.linkLocked(o, path)
+}
+
+func (s *ContentStore) linkLocked(o ContentObject, path string) error {
+	obj := s.objectPath(o)
+
+	objInfo, err := os.Stat(obj)
+	if err != nil {
+		return errors.Wrap(err, "stat object")
+	}
+
+	// the object was used so it's kept longer by the sweeper
+	now := time.Now()
+	_ = os.Chtimes(obj, now, now)
+
+	if info, err := os.Stat(path); err == nil && os.SameFile(info, objInfo) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove file")
+	}
+
+	if err := os.Link(obj, path); err == nil {
+		return nil
+	}
+
+	return copyFile(obj, path)
+}
+
+// Add stores the downloaded file of the media. If the same content is already stored
+// the file is replaced with a link to it
+func (s *ContentStore) Add(tweetID, mediaKey, path string) (ContentObject, error) {
+	hash, size, err := hashFile(path)
+
+	if err != nil {
+		return ContentObject{}, err
+	}
+
+	o := ContentObject{Hash: hash, Ext: strings.ToLower(filepath.Ext(path)), Size: size}
+	obj := s.objectPath(o)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = os.Stat(obj)
+
+	switch {
+	case err == nil:
+		if err := s.linkLocked(o, path); err != nil {
+			return o, errors.Wrap(err, "link object")
+		}
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+			return o, errors.Wrap(err, "create objects folder")
+		}
+		if err := os.Link(path, obj); err != nil {
+			if err := copyFile(path, obj); err != nil {
+				return o, errors.Wrap(err, "store object")
+			}
+		}
+	default:
+		return o, errors.Wrap(err, "stat object")
+	}
+
+	s.index[fileRefKey(tweetID, mediaKey)] = o
+
+	if err := s.saveLocked(); err != nil {
+		return o, errors.Wrap(err, "save content index")
+	}
+
+	return o, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", 0, errors.Wrap(err, "open file")
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+
+	if err != nil {
+		return "", 0, errors.Wrap(err, "hash file")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// copies through a temporary file so there is no partial destination
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	_, err = io.Copy(tmp, in)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "copy file")
+	}
+
+	return nil
+}
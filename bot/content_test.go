@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestContentStoreDedup(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// every photo has the same content
+		_, _ = w.Write(append(magicJPEG, "photo"...))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	content, err := NewContentStore(path.Join(dir, contentDirName), path.Join(dir, "content_index.json"))
+	require.NoError(t, err)
+
+	d := NewDownloader(WithContentStore(content))
+
+	tweet := func(id string) *twitter.TweetData {
+		return &twitter.TweetData{
+			Url:    twitter.TwitterURL{User: "user", ID: id},
+			Photos: []twitter.Photo{{MediaKey: "a", MediaURLHttps: server.URL + "/" + id + ".jpg"}},
+		}
+	}
+
+	first, _, err := d.DownloadTweetData(context.Background(), tweet("1"), dir)
+	require.NoError(t, err)
+
+	second, _, err := d.DownloadTweetData(context.Background(), tweet("2"), dir)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, requests.Load())
+
+	// the same tweet is linked without downloading
+	again, _, err := d.DownloadTweetData(context.Background(), tweet("1"), path.Join(dir, "again"))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, requests.Load())
+	require.Equal(t, first[0].Size, again[0].Size)
+
+	stat := func(p string) os.FileInfo {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		return info
+	}
+
+	require.True(t, os.SameFile(stat(first[0].Path), stat(second[0].Path)))
+	require.True(t, os.SameFile(stat(first[0].Path), stat(again[0].Path)))
+
+	// the index survives restarts
+	content, err = NewContentStore(content.Dir, content.IndexFile)
+	require.NoError(t, err)
+
+	obj, ok := content.Lookup("2", "a")
+	require.True(t, ok)
+	require.Equal(t, first[0].Size, obj.Size)
+
+	// links are counted once and removed together
+	sweeper := NewSweeper(dir, RetentionOptions{MaxTotalSize: 1}, zap.NewNop())
+
+	result, err := sweeper.Sweep(false)
+	require.NoError(t, err)
+	require.Equal(t, 4, result.Removed)
+	require.Equal(t, first[0].Size, result.Freed)
+
+	_, ok = content.Lookup("1", "a")
+	require.False(t, ok)
+}
@@ -1,33 +1,145 @@
 package bot
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/go-resty/resty/v2"
 	"github.com/nktknshn/go-twitter-download-bot/cli/logging"
+	"github.com/nktknshn/go-twitter-download-bot/mp4"
 	"github.com/nktknshn/go-twitter-download-bot/twitter"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
 type Downloader struct {
 	httpClient *resty.Client
 	Retries    int
-	logger     *zap.Logger
+	// backoff between retries grows from MinWait up to MaxWait
+	MinWait time.Duration
+	MaxWait time.Duration
+	logger  *zap.Logger
+
+	filenameTemplate *FilenameTemplate
+
+	// concurrent downloads of a tweet media
+	jobConcurrency int
+	// concurrent downloads of all the jobs
+	global *semaphore.Weighted
+
+	// optional, media found in it are linked instead of downloaded
+	content *ContentStore
+
+	bandwidth       BandwidthLimits
+	globalBandwidth *rate.Limiter
+
+	// optional, files held by other jobs are not removed after failures
+	sweeper *Sweeper
+}
+
+type downloaderOption func(*Downloader)
+
+// WithDownloaderRateLimiter paces media requests with the limiter shared with the twitter client
+func WithDownloaderRateLimiter(rl *twitter.RateLimiter) downloaderOption {
+	return func(d *Downloader) {
+		rl.Apply(d.httpClient)
+	}
+}
+
+// WithDownloaderConcurrency limits the concurrent downloads per tweet and in total. Zero means no limit
+func WithDownloaderConcurrency(perJob, global int) downloaderOption {
+	return func(d *Downloader) {
+		d.jobConcurrency = perJob
+		d.global = nil
+		if global > 0 {
+			d.global = semaphore.NewWeighted(int64(global))
+		}
+	}
+}
+
+// WithFilenameTemplate sets how downloaded files are named
+func WithFilenameTemplate(t *FilenameTemplate) downloaderOption {
+	return func(d *Downloader) {
+		if t != nil {
+			d.filenameTemplate = t
+		}
+	}
+}
+
+// WithDownloaderTransport sets the transport, by default it's twitter.SharedTransport
+func WithDownloaderTransport(t http.RoundTripper) downloaderOption {
+	return func(d *Downloader) {
+		d.httpClient.SetTransport(t)
+	}
+}
+
+// WithBandwidthLimits throttles the media bodies. The job limit applies to the downloads
+// with the same JobContext
+func WithBandwidthLimits(limits BandwidthLimits) downloaderOption {
+	return func(d *Downloader) {
+		d.bandwidth = limits
+		d.globalBandwidth = newByteLimiter(limits.Global)
+	}
+}
+
+// WithDownloaderSweeper keeps the files held in the sweeper when a download fails
+func WithDownloaderSweeper(s *Sweeper) downloaderOption {
+	return func(d *Downloader) {
+		d.sweeper = s
+	}
+}
+
+// WithContentStore stores the downloaded media by content
+func WithContentStore(c *ContentStore) downloaderOption {
+	return func(d *Downloader) {
+		d.content = c
+	}
 }
 
-func NewDownloader() *Downloader {
-	return &Downloader{
-		logger:     logging.GetLogger().Named("downloader"),
-		httpClient: resty.New(),
+func NewDownloader(opts ...downloaderOption) *Downloader {
+	defaultTemplate, _ := ParseFilenameTemplate(DefaultFilenameTemplate)
+
+	d := &Downloader{
+		filenameTemplate: defaultTemplate,
+		logger:           logging.GetLogger().Named("downloader"),
+		httpClient:       resty.New().SetTransport(twitter.SharedTransport()),
 		// could have used resty.New().SetRetryCount(3),
 		Retries: 3,
+		MinWait: 500 * time.Millisecond,
+		MaxWait: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(d)
 	}
+
+	return d
 }
 
 type Downloaded struct {
-	Path   string
-	Entity Downloadable
+	// empty if the media was streamed
+	Path string
+	// relative path made by the filename template
+	Key      string
+	Name     string
+	MediaKey string
+	Entity   Downloadable
+	Size     int64
+	Video    VideoAttributes
+	// poster image of a video, empty if none
+	PosterURL string
 }
 
 func (d Downloaded) IsPhoto() bool {
@@ -40,8 +152,49 @@ func (d Downloaded) IsVideo() bool {
 	return ok
 }
 
-func (d *Downloader) Filename(td *twitter.TweetData, withfn interface{ Filename() string }) string {
-	return td.Url.User + "_" + td.Url.ID + "_" + withfn.Filename()
+// Filename of the media by the template. index is the position of the media in the tweet
+func (d *Downloader) Filename(td *twitter.TweetData, index int, m tweetMedia) (string, error) {
+	return d.filenameTemplate.Execute(filenameData(td, index, m))
+}
+
+// Filenames of the tweet media. Media rendered to the same name get the index appended
+func (d *Downloader) Filenames(td *twitter.TweetData, media []tweetMedia) ([]string, error) {
+	names := make([]string, len(media))
+
+	for i, m := range media {
+		name, err := d.Filename(td, i, m)
+		if err != nil {
+			return nil, err
+		}
+		names[i] = name
+	}
+
+	return uniqueFilenames(names)
+}
+
+func uniqueFilenames(names []string) ([]string, error) {
+	count := make(map[string]int, len(names))
+	for _, name := range names {
+		count[name]++
+	}
+
+	seen := make(map[string]bool, len(names))
+
+	for i, name := range names {
+		if count[name] > 1 {
+			ext := path.Ext(name)
+			name = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), i+1, ext)
+		}
+
+		if seen[name] {
+			return nil, errors.Errorf("filename %q is used by several media", name)
+		}
+
+		seen[name] = true
+		names[i] = name
+	}
+
+	return names, nil
 }
 
 type Downloadable interface {
@@ -49,13 +202,44 @@ type Downloadable interface {
 	URL() string
 }
 
-func (d *Downloader) DownloadTweetData(td *twitter.TweetData, destDir string) ([]Downloaded, error) {
+type tweetMedia struct {
+	MediaKey  string
+	Entity    Downloadable
+	Video     VideoAttributes
+	PosterURL string
+}
+
+// VideoAttributes are sent with a video so telegram shows it with the right
+// aspect ratio and streams it. Zero if unknown
+type VideoAttributes struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+	// no audio track, read from the file
+	Silent bool
+}
 
-	var toDownload = make([]Downloadable, 0, 4)
-	var downloads []Downloaded
+// the resolution of the variant is preferred as the original may be larger
+func videoAttributes(v twitter.Video, variant twitter.VideoVariant) VideoAttributes {
+	a := VideoAttributes{Width: v.Width, Height: v.Height, Duration: v.Duration()}
+
+	if w, h, ok := variant.Resolution(); ok {
+		a.Width, a.Height = w, h
+	}
+
+	return a
+}
+
+// media of the tweet in the order it is sent
+func tweetMediaList(td *twitter.TweetData) []tweetMedia {
+	var media = make([]tweetMedia, 0, 4)
 
 	for _, p := range td.Photos {
-		toDownload = append(toDownload, p)
+		key := p.MediaKey
+		if key == "" {
+			key = p.Filename()
+		}
+		media = append(media, tweetMedia{MediaKey: key, Entity: p})
 	}
 
 	for _, v := range td.Videos {
@@ -63,43 +247,479 @@ func (d *Downloader) DownloadTweetData(td *twitter.TweetData, destDir string) ([
 		if !ok {
 			continue
 		}
-		toDownload = append(toDownload, best)
+		media = append(media, tweetMedia{MediaKey: v.MediaKey, Entity: best, Video: videoAttributes(v, best), PosterURL: v.PosterURL})
+	}
+
+	return media
+}
+
+// DownloadTweetData downloads the media concurrently. The result is in the order of the tweet media.
+// The files are held in the sweeper from the start until the returned function is called.
+// If any download fails the files made by the others are removed unless other jobs hold them
+func (d *Downloader) DownloadTweetData(ctx context.Context, td *twitter.TweetData, destDir string) ([]Downloaded, func(), error) {
+	media := tweetMediaList(td)
+	downloads := make([]Downloaded, len(media))
+	// the files made by this call
+	created := make([]bool, len(media))
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	if d.jobConcurrency > 0 {
+		g.SetLimit(d.jobConcurrency)
+	}
+
+	names, err := d.Filenames(td, media)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, m := range media {
+		name := names[i]
+		downloads[i] = Downloaded{Path: path.Join(destDir, name), Key: name, Name: path.Base(name), MediaKey: m.MediaKey, Entity: m.Entity, Video: m.Video, PosterURL: m.PosterURL}
+	}
+
+	release := func() {}
+
+	if d.sweeper != nil {
+		release = d.sweeper.Hold(downloadPaths(downloads)...)
 	}
 
-	for _, p := range toDownload {
-		path := path.Join(destDir, d.Filename(td, p))
-		if err := d.Download(p.URL(), path); err != nil {
-			return nil, errors.Wrap(err, "failed to download photo")
+	progress := downloadProgressFrom(ctx)
+
+	for i, m := range media {
+		i, m := i, m
+		path := downloads[i].Path
+
+		report := func(done, total int64) {
+			if progress != nil {
+				progress(i, done, total)
+			}
 		}
-		downloads = append(downloads, Downloaded{Path: path, Entity: p})
+
+		g.Go(func() error {
+			if d.linkStored(td, m, &downloads[i]) {
+				created[i] = true
+				d.prepareVideo(&downloads[i])
+				report(downloads[i].Size, downloads[i].Size)
+				return nil
+			}
+
+			if d.global != nil {
+				if err := d.global.Acquire(gctx, 1); err != nil {
+					return errors.Wrap(err, "wait for download slot")
+				}
+				defer d.global.Release(1)
+			}
+
+			if err := d.Download(withBytesProgress(gctx, report), m.Entity.URL(), path); err != nil {
+				return errors.Wrapf(err, "download %s", m.Entity.Filename())
+			}
+
+			created[i] = true
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				return errors.Wrap(err, "failed to stat download")
+			}
+
+			downloads[i].Size = stat.Size()
+			d.prepareVideo(&downloads[i])
+			report(stat.Size(), stat.Size())
+
+			if d.content != nil {
+				if _, err := d.content.Add(td.Url.ID, m.MediaKey, path); err != nil {
+					d.logger.Error("failed to store media by content", zap.String("path", path), zap.Error(err))
+				}
+			}
+
+			return nil
+		})
 	}
 
-	return downloads, nil
+	if err := g.Wait(); err != nil {
+		defer release()
+
+		for i, dl := range downloads {
+			// the part files are removed by Download
+			if !created[i] || d.sweeper != nil && d.sweeper.holds(dl.Path) > 1 {
+				continue
+			}
+			if err := os.Remove(dl.Path); err != nil && !os.IsNotExist(err) {
+				d.logger.Error("failed to remove download", zap.String("path", dl.Path), zap.Error(err))
+			}
+		}
+		return nil, nil, err
+	}
+
+	return downloads, release, nil
+}
+
+// links the media from the content store if it was downloaded before
+func (d *Downloader) linkStored(td *twitter.TweetData, m tweetMedia, download *Downloaded) bool {
+	if d.content == nil {
+		return false
+	}
+
+	obj, ok := d.content.Lookup(td.Url.ID, m.MediaKey)
+
+	if !ok {
+		return false
+	}
+
+	if err := d.content.Link(obj, download.Path); err != nil {
+		d.logger.Error("failed to link stored media", zap.String("path", download.Path), zap.Error(err))
+		return false
+	}
+
+	d.logger.Info("Media is already stored", zap.String("mediaKey", m.MediaKey), zap.String("hash", obj.Hash))
+	download.Size = obj.Size
+
+	return true
 }
 
-// path must include filename
-func (d *Downloader) Download(url, path string) error {
-	retries := d.Retries
+// moves the moov of the video to the start so it can be streamed and reads
+// the attributes from the file. The attributes from the tweet are kept on errors
+func (d *Downloader) prepareVideo(download *Downloaded) {
+	if !download.IsVideo() {
+		return
+	}
+
+	moved, err := mp4.FaststartFile(download.Path)
+
+	if err != nil {
+		d.logger.Warn("failed to faststart video", zap.String("path", download.Path), zap.Error(err))
+	} else if moved {
+		d.logger.Info("Moved moov to the start", zap.String("path", download.Path))
+	}
+
+	info, err := mp4.ReadFile(download.Path)
+
+	if err != nil {
+		d.logger.Warn("failed to read video", zap.String("path", download.Path), zap.Error(err))
+		return
+	}
+
+	if info.Width > 0 && info.Height > 0 {
+		download.Video.Width, download.Video.Height = info.Width, info.Height
+	}
+
+	if info.Duration > 0 {
+		download.Video.Duration = info.Duration
+	}
+
+	download.Video.Silent = info.Silent()
+}
+
+// MediaStream is the body of a media being downloaded. Size is -1 if unknown
+type MediaStream struct {
+	io.ReadCloser
+	Size int64
+}
 
-	for {
-		resp, err := d.httpClient.R().SetOutput(path).Get(url)
+// Open starts downloading the url and returns the body. Only opening is retried
+func (d *Downloader) Open(ctx context.Context, url string) (*MediaStream, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := d.httpClient.R().SetContext(ctx).SetDoNotParseResponse(true).Get(url)
 
-		if resp.IsSuccess() && err == nil {
+		if err == nil && resp.IsSuccess() {
+			body := resp.RawBody()
+			return &MediaStream{
+				ReadCloser: limitedReadCloser{Reader: d.limitReader(ctx, body), Closer: body},
+				Size:       resp.RawResponse.ContentLength,
+			}, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "open")
+		}
+
+		if err == nil {
+			resp.RawBody().Close()
+			err = errors.Errorf("status %d", resp.StatusCode())
+		}
+
+		retriesLeft := d.Retries - attempt
+
+		d.logger.Error("failed to open", zap.String("url", url), zap.Error(err), zap.Int("retriesLeft", retriesLeft))
+
+		if retriesLeft <= 0 || !isRetryable(resp) {
+			return nil, errors.Wrap(err, "failed to open")
+		}
+
+		if err := sleepContext(ctx, d.backoff(attempt, resp)); err != nil {
+			return nil, errors.Wrap(err, "open")
+		}
+	}
+}
+
+// files being downloaded have this suffix until complete
+const partSuffix = ".part"
+
+// resuming after progress resets the retries, this limits the requests in total
+const downloadMaxAttempts = 20
+
+// errPartFile is a failure to write the downloaded data locally. It's not retried
+var errPartFile = errors.New("part file")
+
+// path must include filename. The file is written to a part file of its own, resumed with range
+// requests after interruptions and renamed to path when complete and valid. Concurrent downloads
+// to the same path don't share the part, the last one renamed wins
+func (d *Downloader) Download(ctx context.Context, url, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+partSuffix)
+	if err != nil {
+		return errors.Wrap(err, "create part")
+	}
+
+	part := f.Name()
+	f.Close()
+
+	// nothing to remove once renamed
+	defer os.Remove(part)
+
+	for attempt, total := 0, 1; ; attempt, total = attempt+1, total+1 {
+		resp, written, err := d.downloadPart(ctx, url, part)
+
+		if err == nil {
 			break
 		}
 
-		d.logger.Error("failed to download", zap.String("url", url), zap.String("path", path), zap.Error(err), zap.Int("retriesLeft", retries))
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "download")
+		}
+
+		// the connection dropped after some progress, keep resuming
+		if written > 0 {
+			attempt = 0
+		}
+
+		retriesLeft := min(d.Retries-attempt, downloadMaxAttempts-total)
 
-		if retries == 0 && err != nil {
+		d.logger.Error("failed to download", zap.String("url", url), zap.String("path", path), zap.Error(err), zap.Int("retriesLeft", retriesLeft))
+
+		if retriesLeft <= 0 || errors.Is(err, errPartFile) || !isRetryable(resp) {
 			return errors.Wrap(err, "failed to download")
 		}
 
-		if retries == 0 && resp.IsError() {
-			return errors.New("failed to download")
+		if err := sleepContext(ctx, d.backoff(attempt, resp)); err != nil {
+			return errors.Wrap(err, "download")
 		}
+	}
+
+	if err := validateFileMagic(part, path); err != nil {
+		return errors.Wrap(err, "validate download")
+	}
 
-		retries--
+	if err := os.Rename(part, path); err != nil {
+		return errors.Wrap(err, "rename download")
 	}
 
 	return nil
 }
+
+// appends the rest of the file to part. Returns the number of bytes written
+func (d *Downloader) downloadPart(ctx context.Context, url, part string) (*resty.Response, int64, error) {
+	var offset int64
+
+	if stat, err := os.Stat(part); err == nil {
+		offset = stat.Size()
+	}
+
+	req := d.httpClient.R().SetContext(ctx).SetDoNotParseResponse(true)
+
+	if offset > 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := req.Get(url)
+
+	if err != nil {
+		return resp, 0, err
+	}
+
+	body := resp.RawBody()
+	defer body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := int64(-1)
+
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		// range is not supported or it's the first request
+		flags |= os.O_TRUNC
+		offset = 0
+		total = resp.RawResponse.ContentLength
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header().Get("Content-Range"))
+		if err != nil || start != offset {
+			_ = os.Remove(part)
+			return resp, 0, errors.Errorf("unexpected content range %q", resp.Header().Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part is complete already
+		if _, size, err := parseContentRange(resp.Header().Get("Content-Range")); err == nil && size == offset {
+			return resp, 0, nil
+		}
+		_ = os.Remove(part)
+		return resp, 0, errors.New("range not satisfiable")
+	default:
+		return resp, 0, errors.Errorf("status %d", resp.StatusCode())
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+
+	if err != nil {
+		return resp, 0, errors.Wrapf(errPartFile, "open: %v", err)
+	}
+
+	r := d.limitReader(ctx, body)
+
+	if report := bytesProgressFrom(ctx); report != nil {
+		r = &progressReader{r: r, done: offset, total: total, report: report}
+	}
+
+	w := &partWriter{f: f}
+	written, err := io.Copy(w, r)
+
+	if closeErr := f.Close(); closeErr != nil && w.err == nil {
+		w.err = closeErr
+	}
+
+	if w.err != nil {
+		return resp, written, errors.Wrapf(errPartFile, "write: %v", w.err)
+	}
+
+	if err != nil {
+		return resp, written, errors.Wrap(err, "read body")
+	}
+
+	if total >= 0 && offset+written != total {
+		if offset+written > total {
+			_ = os.Remove(part)
+		}
+		return resp, written, errors.Errorf("got %d bytes of %d", offset+written, total)
+	}
+
+	return resp, written, nil
+}
+
+// keeps the write error apart from the body read errors of io.Copy
+type partWriter struct {
+	f   *os.File
+	err error
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// parses "bytes start-end/size" and "bytes */size". Unknown size is -1
+func parseContentRange(header string) (start, size int64, err error) {
+	rng, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, errors.Errorf("invalid content range %q", header)
+	}
+
+	span, total, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, errors.Errorf("invalid content range %q", header)
+	}
+
+	size = -1
+
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, errors.Wrap(err, "content range size")
+		}
+	}
+
+	if span == "*" {
+		return 0, size, nil
+	}
+
+	first, _, _ := strings.Cut(span, "-")
+
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, errors.Wrap(err, "content range start")
+	}
+
+	return start, size, nil
+}
+
+// network errors, dropped transfers, timeouts, rate limiting and server errors are worth retrying.
+// A successful response means the body was cut, local file errors are checked by the caller
+func isRetryable(resp *resty.Response) bool {
+	if resp == nil || resp.RawResponse == nil {
+		return true
+	}
+
+	switch code := resp.StatusCode(); {
+	case resp.IsSuccess():
+		return true
+	case code == http.StatusRequestedRangeNotSatisfiable:
+		return true
+	case code == http.StatusRequestTimeout, code == http.StatusTooEarly, code == http.StatusTooManyRequests:
+		return true
+	case code >= 500:
+		return true
+	}
+
+	return false
+}
+
+// exponential backoff with jitter. Retry-After of the response is respected up to MaxWait
+func (d *Downloader) backoff(attempt int, resp *resty.Response) time.Duration {
+	if resp != nil && resp.RawResponse != nil {
+		if secs, err := strconv.Atoi(resp.Header().Get("Retry-After")); err == nil && secs > 0 {
+			return min(time.Duration(secs)*time.Second, d.MaxWait)
+		}
+	}
+
+	wait := d.MinWait << attempt
+
+	if wait <= 0 || wait > d.MaxWait {
+		wait = d.MaxWait
+	}
+
+	// between a half and the full wait
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func downloadPaths(downloads []Downloaded) []string {
+	paths := make([]string, 0, len(downloads))
+	for _, d := range downloads {
+		if d.Path != "" {
+			paths = append(paths, d.Path)
+		}
+	}
+	return paths
+}
+
+func totalSize(downloads []Downloaded) int64 {
+	var total int64
+	for _, d := range downloads {
+		total += d.Size
+	}
+	return total
+}
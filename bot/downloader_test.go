@@ -0,0 +1,299 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDownloaderRetry(t *testing.T) {
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case requests < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte("data"))
+		}
+	}))
+	defer server.Close()
+
+	d := NewDownloader()
+	d.MinWait = time.Millisecond
+	d.MaxWait = 5 * time.Millisecond
+
+	dest := path.Join(t.TempDir(), "file")
+
+	require.NoError(t, d.Download(context.Background(), server.URL+"/file", dest))
+	require.Equal(t, 3, requests)
+
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+
+	// not retryable
+	requests = 0
+	require.Error(t, d.Download(context.Background(), server.URL+"/missing", dest))
+	require.Equal(t, 1, requests)
+}
+
+func TestDownloadTweetData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(append(magicJPEG, r.URL.Path...))
+	}))
+	defer server.Close()
+
+	d := NewDownloader(WithDownloaderConcurrency(2, 0))
+
+	td := &twitter.TweetData{
+		Url: twitter.TwitterURL{User: "user", ID: "1"},
+		Photos: []twitter.Photo{
+			{MediaKey: "a", MediaURLHttps: server.URL + "/a.jpg"},
+			{MediaKey: "b", MediaURLHttps: server.URL + "/b.jpg"},
+			{MediaKey: "c", MediaURLHttps: server.URL + "/c.jpg"},
+		},
+	}
+
+	dir := t.TempDir()
+
+	downloads, _, err := d.DownloadTweetData(context.Background(), td, dir)
+	require.NoError(t, err)
+	require.Len(t, downloads, 3)
+
+	for i, key := range []string{"a", "b", "c"} {
+		require.Equal(t, key, downloads[i].MediaKey)
+		require.Equal(t, int64(len(magicJPEG)+len("/"+key+".jpg")), downloads[i].Size)
+	}
+
+	// the downloaded files are removed when one fails
+	td.Photos = append(td.Photos, twitter.Photo{MediaKey: "d", MediaURLHttps: server.URL + "/missing.jpg"})
+	dir = t.TempDir()
+
+	_, _, err = d.DownloadTweetData(context.Background(), td, dir)
+	require.Error(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// the files held by other jobs and the ones not made by the call are kept
+	dir = t.TempDir()
+	media := tweetMediaList(td)
+	paths := make([]string, len(media))
+
+	for i, m := range media {
+		name, err := d.Filename(td, i, m)
+		require.NoError(t, err)
+		paths[i] = path.Join(dir, name)
+	}
+
+	require.NoError(t, os.WriteFile(paths[3], magicJPEG, 0644))
+
+	sweeper := NewSweeper(dir, RetentionOptions{}, zap.NewNop())
+	defer sweeper.Hold(paths[0])()
+
+	d = NewDownloader(WithDownloaderConcurrency(2, 0), WithDownloaderSweeper(sweeper))
+
+	_, _, err = d.DownloadTweetData(context.Background(), td, dir)
+	require.Error(t, err)
+
+	require.FileExists(t, paths[0])
+	require.NoFileExists(t, paths[1])
+	require.NoFileExists(t, paths[2])
+	require.FileExists(t, paths[3])
+	require.False(t, sweeper.isHeld(paths[1]))
+
+	// the files are held until the caller releases them
+	td.Photos = td.Photos[:3]
+
+	downloads, release, err := d.DownloadTweetData(context.Background(), td, dir)
+	require.NoError(t, err)
+	require.True(t, sweeper.isHeld(downloads[1].Path))
+
+	release()
+	require.False(t, sweeper.isHeld(downloads[1].Path))
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := append(magicJPEG, bytes.Repeat([]byte("x"), 1000)...)
+
+	var requests int
+	var rangeHeader string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.URL.Path == "/error.jpg" {
+			_, _ = w.Write([]byte("<!DOCTYPE html><html></html>"))
+			return
+		}
+
+		if requests == 1 {
+			// drop the connection in the middle
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:500])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		rangeHeader = r.Header.Get("Range")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	d := NewDownloader()
+	d.MinWait = time.Millisecond
+	d.MaxWait = 5 * time.Millisecond
+
+	dest := path.Join(t.TempDir(), "photo.jpg")
+
+	require.NoError(t, d.Download(context.Background(), server.URL+"/photo.jpg", dest))
+	require.Equal(t, 2, requests)
+	require.Equal(t, "bytes=500-", rangeHeader)
+
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	parts, err := filepath.Glob(dest + ".*" + partSuffix)
+	require.NoError(t, err)
+	require.Empty(t, parts)
+
+	dest = path.Join(t.TempDir(), "error.jpg")
+
+	err = d.Download(context.Background(), server.URL+"/error.jpg", dest)
+	require.ErrorIs(t, err, errBadContent)
+
+	_, err = os.Stat(dest)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadSamePath(t *testing.T) {
+	content := append(magicJPEG, bytes.Repeat([]byte("x"), 100000)...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough for the downloads to overlap
+		for i := 0; i < len(content); i += 10000 {
+			_, _ = w.Write(content[i:min(i+10000, len(content))])
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDownloader()
+	dest := path.Join(t.TempDir(), "photo.jpg")
+
+	var g errgroup.Group
+
+	for i := 0; i < 4; i++ {
+		g.Go(func() error {
+			return d.Download(context.Background(), server.URL+"/photo.jpg", dest)
+		})
+	}
+
+	require.NoError(t, g.Wait())
+
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+}
+
+func TestDownloadMaxAttempts(t *testing.T) {
+	var requests int
+
+	// every response makes some progress and drops
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write(magicJPEG)
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+
+	d := NewDownloader()
+	d.MinWait = time.Millisecond
+	d.MaxWait = time.Millisecond
+
+	err := d.Download(context.Background(), server.URL+"/photo.jpg", path.Join(t.TempDir(), "photo.jpg"))
+	require.Error(t, err)
+	require.Equal(t, downloadMaxAttempts, requests)
+}
+
+func TestDownloadProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(append(magicJPEG, bytes.Repeat([]byte("x"), 1000)...))
+	}))
+	defer server.Close()
+
+	td := &twitter.TweetData{
+		Url: twitter.TwitterURL{User: "user", ID: "1"},
+		Photos: []twitter.Photo{
+			{MediaKey: "a", MediaURLHttps: server.URL + "/a.jpg"},
+			{MediaKey: "b", MediaURLHttps: server.URL + "/b.jpg"},
+		},
+	}
+
+	status := &jobStatus{}
+	status.setStage(stageDownload, 2, false)
+	require.Equal(t, "Скачиваю 1/2, 0%... Downloading 1/2, 0%...", status.textLocked())
+
+	status.setProgress(0, 500, 1000)
+	require.Equal(t, "Скачиваю 1/2, 50%... Downloading 1/2, 50%...", status.textLocked())
+
+	ctx := WithDownloadProgress(context.Background(), status.setProgress)
+
+	_, _, err := NewDownloader().DownloadTweetData(ctx, td, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, "Скачиваю 2/2, 100%... Downloading 2/2, 100%...", status.textLocked())
+	require.Equal(t, &tg.SendMessageUploadPhotoAction{Progress: 100}, status.action())
+}
+
+func TestTweetMediaVideoAttributes(t *testing.T) {
+	td := &twitter.TweetData{
+		Photos: []twitter.Photo{{MediaKey: "3_1", MediaURLHttps: "https://pbs.twimg.com/media/a.jpg"}},
+		Videos: []twitter.Video{
+			{
+				MediaKey: "7_1", Width: 1920, Height: 1080, DurationMillis: 1500,
+				Variants: twitter.VideoVariants{
+					{Bitrate: 2176000, ContentType: "video/mp4", VideoURL: "https://video.twimg.com/vid/1280x720/b.mp4"},
+				},
+			},
+			{
+				MediaKey: "7_2", Width: 640, Height: 480,
+				Variants: twitter.VideoVariants{
+					{Bitrate: 832000, ContentType: "video/mp4", VideoURL: "https://video.twimg.com/tweet_video/c.mp4"},
+				},
+			},
+		},
+	}
+
+	media := tweetMediaList(td)
+	require.Len(t, media, 3)
+
+	require.Equal(t, VideoAttributes{}, media[0].Video)
+	require.Equal(t, VideoAttributes{Width: 1280, Height: 720, Duration: 1500 * time.Millisecond}, media[1].Video)
+	require.Equal(t, VideoAttributes{Width: 640, Height: 480}, media[2].Video)
+}
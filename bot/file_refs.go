@@ -0,0 +1,303 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+)
+
+type FileRefKind string
+
+const (
+	FileRefPhoto    FileRefKind = "photo"
+	FileRefDocument FileRefKind = "document"
+)
+
+// FileRef is a reference to a media already uploaded to telegram. Implements message.FileLocation
+type FileRef struct {
+	Kind          FileRefKind `json:"kind"`
+	ID            int64       `json:"id"`
+	AccessHash    int64       `json:"access_hash"`
+	FileReference []byte      `json:"file_reference"`
+}
+
+func (f FileRef) GetID() int64 {
+	return f.ID
+}
+
+func (f FileRef) GetAccessHash() int64 {
+	return f.AccessHash
+}
+
+func (f FileRef) GetFileReference() []byte {
+	return f.FileReference
+}
+
+// returns the reference to the photo or document of a sent message
+func fileRefFromMessage(m *tg.Message) (FileRef, bool) {
+	switch media := m.Media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := media.Photo.(*tg.Photo)
+		if !ok {
+			return FileRef{}, false
+		}
+		return FileRef{
+			Kind:          FileRefPhoto,
+			ID:            photo.ID,
+			AccessHash:    photo.AccessHash,
+			FileReference: photo.FileReference,
+		}, true
+	case *tg.MessageMediaDocument:
+		doc, ok := media.Document.(*tg.Document)
+		if !ok {
+			return FileRef{}, false
+		}
+		return FileRef{
+			Kind:          FileRefDocument,
+			ID:            doc.ID,
+			AccessHash:    doc.AccessHash,
+			FileReference: doc.FileReference,
+		}, true
+	}
+
+	return FileRef{}, false
+}
+
+// default max number of references kept
+const defaultMaxFileRefs = 50000
+
+type fileRefEntry struct {
+	Key string `json:"key"`
+	// nil removes the key
+	Ref    *FileRef  `json:"ref,omitempty"`
+	Stored time.Time `json:"stored,omitempty"`
+}
+
+// FileRefStore keeps file references keyed by tweet id and media key. Changes are appended
+// to a json lines file which is rewritten when it gets much longer than the kept references
+type FileRefStore struct {
+	Filepath string
+	// the oldest references are evicted over it. Zero means no limit
+	MaxRefs int
+
+	refs  map[string]fileRefEntry
+	file  *os.File
+	lines int
+	mutex *sync.Mutex
+
+	nowFunc func() time.Time
+}
+
+func NewFileRefStore(filepath string) (*FileRefStore, error) {
+	s := &FileRefStore{
+		Filepath: filepath,
+		MaxRefs:  defaultMaxFileRefs,
+		refs:     make(map[string]fileRefEntry),
+		mutex:    &sync.Mutex{},
+		nowFunc:  time.Now,
+	}
+
+	if err := s.Load(); err != nil {
+		return nil, errors.Wrap(err, "load file refs")
+	}
+
+	return s, nil
+}
+
+func fileRefKey(tweetID, mediaKey string) string {
+	return tweetID + "/" + mediaKey
+}
+
+// Load reads the file and opens it for appending
+func (s *FileRefStore) Load() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.refs = make(map[string]fileRefEntry)
+	s.lines = 0
+
+	data, err := os.ReadFile(s.Filepath)
+
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read file")
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		s.lines++
+
+		var e fileRefEntry
+
+		// a line cut by a crash is dropped on the next compaction
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+
+		s.applyLocked(e)
+	}
+
+	return s.openLocked()
+}
+
+func (s *FileRefStore) openLocked() error {
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	f, err := os.OpenFile(s.Filepath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	s.file = f
+
+	return nil
+}
+
+func (s *FileRefStore) applyLocked(e fileRefEntry) {
+	if e.Ref == nil {
+		delete(s.refs, e.Key)
+	} else {
+		s.refs[e.Key] = e
+	}
+}
+
+// appends the changes and compacts the file if needed
+func (s *FileRefStore) appendLocked(entries []fileRefEntry) error {
+	var buf bytes.Buffer
+
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return errors.Wrap(err, "marshal")
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+		s.applyLocked(e)
+	}
+
+	if s.MaxRefs > 0 && len(s.refs) > s.MaxRefs {
+		s.evictLocked()
+		return s.compactLocked()
+	}
+
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		return errors.Wrap(err, "append")
+	}
+
+	s.lines += len(entries)
+
+	// the removed and replaced references take most of the file
+	if s.lines > 2*len(s.refs)+100 {
+		return s.compactLocked()
+	}
+
+	return nil
+}
+
+// drops the oldest references down to 90% of the limit so it's not done on every Set
+func (s *FileRefStore) evictLocked() {
+	entries := make([]fileRefEntry, 0, len(s.refs))
+	for _, e := range s.refs {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Stored.Before(entries[j].Stored) })
+
+	for _, e := range entries[:len(entries)-s.MaxRefs*9/10] {
+		delete(s.refs, e.Key)
+	}
+}
+
+// rewrites the file with the kept references only
+func (s *FileRefStore) compactLocked() error {
+	var buf bytes.Buffer
+
+	for _, e := range s.refs {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return errors.Wrap(err, "marshal")
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeFileAtomic(s.Filepath, buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "compact")
+	}
+
+	s.lines = len(s.refs)
+
+	return s.openLocked()
+}
+
+func (s *FileRefStore) Get(tweetID, mediaKey string) (FileRef, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.refs[fileRefKey(tweetID, mediaKey)]
+	if !ok {
+		return FileRef{}, false
+	}
+	return *e.Ref, true
+}
+
+// Set stores the references of the tweet media keyed by media key
+func (s *FileRefStore) Set(tweetID string, refs map[string]FileRef) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.nowFunc()
+	entries := make([]fileRefEntry, 0, len(refs))
+
+	for mediaKey, ref := range refs {
+		ref := ref
+		entries = append(entries, fileRefEntry{Key: fileRefKey(tweetID, mediaKey), Ref: &ref, Stored: now})
+	}
+
+	return s.appendLocked(entries)
+}
+
+func (s *FileRefStore) Delete(tweetID string, mediaKeys ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make([]fileRefEntry, len(mediaKeys))
+
+	for i, mediaKey := range mediaKeys {
+		entries[i] = fileRefEntry{Key: fileRefKey(tweetID, mediaKey)}
+	}
+
+	return s.appendLocked(entries)
+}
+
+// Len returns the number of kept references
+func (s *FileRefStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.refs)
+}
+
+func (s *FileRefStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFileRefStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file_refs.jsonl")
+
+	s, err := NewFileRefStore(path)
+	require.NoError(t, err)
+
+	_, ok := s.Get("1", "3_1")
+	require.False(t, ok)
+
+	photo := FileRef{Kind: FileRefPhoto, ID: 1, AccessHash: 2, FileReference: []byte{3, 4}}
+	video := FileRef{Kind: FileRefDocument, ID: 5, AccessHash: 6, FileReference: []byte{7}}
+
+	require.NoError(t, s.Set("1", map[string]FileRef{"3_1": photo, "7_2": video}))
+
+	ref, ok := s.Get("1", "3_1")
+	require.True(t, ok)
+	require.Equal(t, photo, ref)
+
+	// the same media key of another tweet
+	_, ok = s.Get("2", "3_1")
+	require.False(t, ok)
+
+	s, err = NewFileRefStore(path)
+	require.NoError(t, err)
+
+	ref, ok = s.Get("1", "7_2")
+	require.True(t, ok)
+	require.Equal(t, video, ref)
+
+	require.NoError(t, s.Delete("1", "3_1"))
+
+	s, err = NewFileRefStore(path)
+	require.NoError(t, err)
+
+	_, ok = s.Get("1", "3_1")
+	require.False(t, ok)
+	_, ok = s.Get("1", "7_2")
+	require.True(t, ok)
+	require.NoError(t, s.Close())
+}
+
+func TestFileRefStoreLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file_refs.jsonl")
+
+	s, err := NewFileRefStore(path)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	s.nowFunc = func() time.Time { return now }
+	s.MaxRefs = 10
+
+	for i := 0; i < 11; i++ {
+		now = now.Add(time.Second)
+		require.NoError(t, s.Set(strconv.Itoa(i), map[string]FileRef{"3_1": {ID: int64(i)}}))
+	}
+
+	// the oldest are evicted down to 90% of the limit
+	require.Equal(t, 9, s.Len())
+	_, ok := s.Get("1", "3_1")
+	require.False(t, ok)
+	_, ok = s.Get("2", "3_1")
+	require.True(t, ok)
+
+	// the same keys again are compacted
+	for i := 0; i < 200; i++ {
+		require.NoError(t, s.Set("10", map[string]FileRef{"3_1": {ID: int64(i)}}))
+	}
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Less(t, bytes.Count(data, []byte("\n")), 2*9+100)
+
+	s, err = NewFileRefStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Equal(t, 9, s.Len())
+	ref, ok := s.Get("10", "3_1")
+	require.True(t, ok)
+	require.Equal(t, int64(199), ref.ID)
+}
+
+type invokerFunc func(ctx context.Context, input bin.Encoder, output bin.Decoder) error
+
+func (f invokerFunc) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	return f(ctx, input, output)
+}
+
+func TestSendFromStaleFileRefs(t *testing.T) {
+	s, err := NewFileRefStore(filepath.Join(t.TempDir(), "file_refs.jsonl"))
+	require.NoError(t, err)
+
+	td := &twitter.TweetData{
+		Url:    twitter.TwitterURL{User: "user", ID: "1"},
+		Photos: []twitter.Photo{{MediaURLHttps: "https://pbs.twimg.com/media/a.jpg", MediaKey: "3_1"}},
+	}
+
+	require.NoError(t, s.Set("1", map[string]FileRef{"3_1": {Kind: FileRefPhoto, ID: 1}}))
+
+	invoked := 0
+	api := tg.NewClient(invokerFunc(func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		invoked++
+		return tgerr.New(400, tg.ErrFileReferenceExpired)
+	}))
+
+	h := &Handler{
+		Logger:   zap.NewNop(),
+		fileRefs: s,
+		sender:   message.NewSender(api),
+	}
+
+	peer := &tg.InputPeerUser{UserID: 1}
+
+	_, err = h.sendFromFileRefs(context.Background(), peer, td, "caption")
+	require.Error(t, err)
+	require.True(t, isStaleFileRef(err))
+	require.Equal(t, 1, invoked)
+
+	_, ok := s.Get("1", "3_1")
+	require.False(t, ok)
+
+	// nothing to send from anymore
+	_, err = h.sendFromFileRefs(context.Background(), peer, td, "caption")
+	require.ErrorIs(t, err, errNoFileRefs)
+	require.Equal(t, 1, invoked)
+}
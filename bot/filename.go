@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+)
+
+// DefaultFilenameTemplate is user_id_name.ext
+const DefaultFilenameTemplate = "{{.Author}}_{{.TweetID}}_{{.Name}}.{{.Ext}}"
+
+// FilenameData are the fields of a filename template
+type FilenameData struct {
+	Author  string
+	TweetID string
+	// decoded from the tweet id
+	Date time.Time
+	// index of the media in the tweet starting from 1
+	Index    int
+	Kind     string
+	MediaKey string
+	// empty if unknown
+	Resolution string
+	// original name without the extension
+	Name string
+	// extension without the dot
+	Ext string
+}
+
+// FilenameTemplate makes media filenames. Slashes in the result make subdirectories
+type FilenameTemplate struct {
+	tmpl *template.Template
+}
+
+// ParseFilenameTemplate parses a text/template like {{.Author}}/{{.Date.Format "2006-01"}}/{{.TweetID}}_{{.Index}}.{{.Ext}}
+func ParseFilenameTemplate(text string) (*FilenameTemplate, error) {
+	tmpl, err := template.New("filename").Parse(text)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "parse filename template")
+	}
+
+	t := &FilenameTemplate{tmpl: tmpl}
+
+	// catch unknown fields early
+	sample := FilenameData{
+		Author: "user", TweetID: "1", Date: time.Now(), Index: 1,
+		Kind: "photo", MediaKey: "3_1", Name: "name", Ext: "jpg",
+	}
+
+	if _, err := t.Execute(sample); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Execute returns the sanitized relative path
+func (t *FilenameTemplate) Execute(data FilenameData) (string, error) {
+	var sb strings.Builder
+
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		return "", errors.Wrap(err, "execute filename template")
+	}
+
+	var parts []string
+
+	for _, part := range strings.Split(sb.String(), "/") {
+		if part = sanitizePathComponent(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "", errors.Errorf("empty filename from template %q", t.tmpl.Root.String())
+	}
+
+	return path.Join(parts...), nil
+}
+
+// replaces characters not allowed in file names and removes relative components
+func sanitizePathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f:
+			return -1
+		case strings.ContainsRune(`\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+
+	s = strings.Trim(s, " .")
+
+	// file names are limited to 255 bytes on most filesystems
+	if len(s) > 200 {
+		s = s[:200]
+	}
+
+	return strings.ToValidUTF8(s, "")
+}
+
+func filenameData(td *twitter.TweetData, index int, m tweetMedia) FilenameData {
+	base := m.Entity.Filename()
+	ext := path.Ext(base)
+
+	data := FilenameData{
+		Author:   td.Author(),
+		TweetID:  td.Url.ID,
+		Index:    index + 1,
+		MediaKey: m.MediaKey,
+		Name:     strings.TrimSuffix(base, ext),
+		Ext:      strings.TrimPrefix(ext, "."),
+	}
+
+	data.Date, _ = twitter.SnowflakeTime(td.Url.ID)
+
+	switch e := m.Entity.(type) {
+	case twitter.Photo:
+		data.Kind = "photo"
+	case twitter.VideoVariant:
+		data.Kind = "video"
+		if w, h, ok := e.Resolution(); ok {
+			data.Resolution = fmt.Sprintf("%dx%d", w, h)
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilenameTemplate(t *testing.T) {
+	td := &twitter.TweetData{Url: twitter.TwitterURL{User: "user", ID: "1742878545549087076"}}
+	video := tweetMedia{MediaKey: "7_1", Entity: twitter.VideoVariant{
+		VideoURL: "https://video.twimg.com/ext_tw_video/1/pu/vid/avc1/720x1280/abc.mp4?tag=12",
+	}}
+
+	tmpl, err := ParseFilenameTemplate(DefaultFilenameTemplate)
+	require.NoError(t, err)
+
+	name, err := tmpl.Execute(filenameData(td, 0, video))
+	require.NoError(t, err)
+	require.Equal(t, "user_1742878545549087076_abc.mp4", name)
+
+	tmpl, err = ParseFilenameTemplate(`{{.Author}}/{{.Date.Format "2006/01"}}/{{.Index}}_{{.Kind}}_{{.Resolution}}.{{.Ext}}`)
+	require.NoError(t, err)
+
+	name, err = tmpl.Execute(filenameData(td, 1, video))
+	require.NoError(t, err)
+	require.Equal(t, "user/2024/01/2_video_720x1280.mp4", name)
+
+	// the handle of the author over the user of the url
+	td.AuthorScreenName = "Author"
+
+	name, err = tmpl.Execute(filenameData(td, 1, video))
+	require.NoError(t, err)
+	require.Equal(t, "Author/2024/01/2_video_720x1280.mp4", name)
+
+	td.AuthorScreenName = ""
+
+	// no escaping the folder
+	td.Url.User = "../x:y"
+	tmpl, err = ParseFilenameTemplate(`/{{.Author}}/{{.MediaKey}}`)
+	require.NoError(t, err)
+
+	name, err = tmpl.Execute(filenameData(td, 0, video))
+	require.NoError(t, err)
+	require.Equal(t, "x_y/7_1", name)
+
+	_, err = ParseFilenameTemplate(`{{.Unknown}}`)
+	require.Error(t, err)
+}
+
+func TestFilenamesCollision(t *testing.T) {
+	td := &twitter.TweetData{
+		Url: twitter.TwitterURL{User: "user", ID: "1"},
+		Photos: []twitter.Photo{
+			{MediaKey: "3_1", MediaURLHttps: "https://pbs.twimg.com/media/a.jpg"},
+			{MediaKey: "3_2", MediaURLHttps: "https://pbs.twimg.com/media/b.jpg"},
+			{MediaKey: "3_3", MediaURLHttps: "https://pbs.twimg.com/media/c.png"},
+		},
+	}
+
+	tmpl, err := ParseFilenameTemplate(`{{.Author}}/{{.TweetID}}.{{.Ext}}`)
+	require.NoError(t, err)
+
+	d := NewDownloader(WithFilenameTemplate(tmpl))
+
+	names, err := d.Filenames(td, tweetMediaList(td))
+	require.NoError(t, err)
+	require.Equal(t, []string{"user/1_1.jpg", "user/1_2.jpg", "user/1.png"}, names)
+
+	_, err = uniqueFilenames([]string{"a.jpg", "a.jpg", "a_2.jpg"})
+	require.Error(t, err)
+}
@@ -3,9 +3,11 @@ package bot
 import (
 	"context"
 	"fmt"
+	"path"
 	"sync"
 	"time"
 
+	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/telegram/message/unpack"
@@ -28,21 +30,69 @@ type Handler struct {
 	forwardTo          int64
 	uploadToAccessHash int64
 	downloadFolder     string
+	// the channel gets the photos as a collage
+	forwardCollage bool
+	// theme of the cards of tweets without media sent to the channel, empty for none
+	forwardCard string
 
 	twitter    *twitter.Twitter
 	downloader *Downloader
 
+	twitterRateLimits twitter.RateLimits
+	breakerSettings   twitter.BreakerSettings
+	breaker           *twitter.CircuitBreaker
+
+	useCache     bool
+	cacheOptions twitter.CacheOptions
+
+	fileRefsFile string
+	fileRefs     *FileRefStore
+
 	selfUsername string
 
 	IncludeText    bool
 	IncludeURL     bool
 	IncludeBotName bool
 
-	limitPerDay  int
+	quota        *Quota
 	limitPending int
 
-	usersMap     map[int64]*UserData
-	usersMapLock sync.RWMutex
+	userStorageKind UserStorageKind
+	userStorageFile string
+	users           UserStorage
+
+	pending     map[int64]int
+	pendingLock sync.Mutex
+
+	workers  int
+	jobs     *JobQueue
+	timeouts StageTimeouts
+
+	downloadsPerJob int
+	downloadsGlobal int
+
+	// upload media as it's downloaded
+	streaming bool
+
+	variantLimits VariantLimits
+
+	collage CollageOptions
+	card    CardOptions
+
+	retention RetentionOptions
+	sweeper   *Sweeper
+
+	filenameTemplate *FilenameTemplate
+
+	// archive of the downloaded media, optional
+	store MediaStore
+
+	// media stored by content in the download folder, optional
+	dedup   bool
+	content *ContentStore
+
+	transport twitter.TransportOptions
+	bandwidth BandwidthLimits
 
 	nowFunc func() time.Time
 }
@@ -57,18 +107,93 @@ func (h *Handler) Init(client *telegram.Client) error {
 		h.nowFunc = time.Now
 	}
 
-	h.usersMap = make(map[int64]*UserData)
-	h.usersMapLock = sync.RWMutex{}
+	h.pending = make(map[int64]int)
+	h.jobs = NewJobQueue(h.Logger.Named("queue"), h.workers)
+	h.sweeper = NewSweeper(h.downloadFolder, h.retention, h.Logger.Named("sweeper"))
+
+	if h.userStorageFile == "" {
+		h.userStorageFile = path.Join(h.downloadFolder, defaultUserStorageFile(h.userStorageKind))
+	}
+
+	var err error
+
+	if h.users, err = NewUserStorage(h.userStorageKind, h.userStorageFile); err != nil {
+		return errors.Wrap(err, "create user storage")
+	}
 
 	h.api = tg.NewClient(client)
 	h.sender = message.NewSender(h.api)
-	h.twitter = twitter.NewTwitter()
-	h.downloader = NewDownloader()
+
+	rateLimiter := twitter.NewRateLimiter(h.twitterRateLimits)
+
+	h.breaker = twitter.NewCircuitBreaker(h.breakerSettings)
+	h.breaker.OnStateChange(h.onBreakerStateChange)
+
+	// one pool of connections for the api and the media
+	transport := twitter.NewTransport(h.transport)
+
+	twitterOpts := []twitter.Option{
+		twitter.WithTransport(transport),
+		twitter.WithRateLimiter(rateLimiter),
+		twitter.WithCircuitBreaker(h.breaker),
+	}
+
+	if h.useCache {
+		cache, err := twitter.NewCache(h.cacheOptions)
+		if err != nil {
+			return errors.Wrap(err, "create tweet cache")
+		}
+		twitterOpts = append(twitterOpts, twitter.WithCache(cache))
+	}
+
+	h.twitter = twitter.NewTwitter(twitterOpts...)
+
+	if h.dedup {
+		h.content, err = NewContentStore(
+			path.Join(h.downloadFolder, contentDirName),
+			path.Join(h.downloadFolder, "content_index.json"),
+		)
+		if err != nil {
+			return errors.Wrap(err, "create content store")
+		}
+	}
+
+	h.downloader = NewDownloader(
+		WithDownloaderRateLimiter(rateLimiter),
+		WithDownloaderConcurrency(h.downloadsPerJob, h.downloadsGlobal),
+		WithFilenameTemplate(h.filenameTemplate),
+		WithContentStore(h.content),
+		WithDownloaderSweeper(h.sweeper),
+		WithDownloaderTransport(transport),
+		WithBandwidthLimits(h.bandwidth),
+	)
+
+	if h.fileRefsFile == "" {
+		h.fileRefsFile = path.Join(h.downloadFolder, "file_refs.jsonl")
+	}
+
+	if h.fileRefs, err = NewFileRefStore(h.fileRefsFile); err != nil {
+		return errors.Wrap(err, "create file refs store")
+	}
+
 	h.dispatcher.OnNewMessage(h.onNewMessage)
 
 	return nil
 }
 
+// Close releases the user storage and the file refs
+func (h *Handler) Close() error {
+	if h.fileRefs != nil {
+		if err := h.fileRefs.Close(); err != nil {
+			h.Logger.Error("failed to close file refs", zap.Error(err))
+		}
+	}
+	if h.users == nil {
+		return nil
+	}
+	return h.users.Close()
+}
+
 func (h *Handler) Handle(ctx context.Context, u tg.UpdatesClass) error {
 	if h.debugTelegram {
 		h.Logger.Debug("update", zap.Any("update", u))
@@ -106,26 +231,54 @@ func (h *Handler) onNewMessage(ctx context.Context, entities tg.Entities, u *tg.
 
 func (h *Handler) onNewMessageTextFromUser(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
 
-	h.initUser(user.UserID)
+	h.initUser(user.UserID, entities)
 
-	if m.Message == "/start" {
+	cmd, args := parseCommand(m.Message)
+
+	switch {
+	case cmd == "/start":
 		return h.onStart(ctx, entities, user, m)
+	case cmd == "/me":
+		return h.onMe(ctx, entities, user, m)
+	case cmd == "/queue":
+		return h.onQueue(ctx, entities, user, m)
+	case cmd == "/cancel":
+		return h.onCancel(ctx, entities, user, m)
+	case cmd == "/collage":
+		return h.onCollage(ctx, entities, user, args)
+	case cmd == "/card":
+		return h.onCard(ctx, entities, user, args)
+	case cmd == "/audio":
+		return h.onAudio(ctx, entities, user, args)
+	case cmd == "/status" && h.isAdmin(user.UserID):
+		return h.onStatus(ctx, entities, user, m)
+	case cmd == "/stats" && h.isAdmin(user.UserID):
+		return h.onStats(ctx, entities, user, m)
+	case cmd == "/tier" && h.isAdmin(user.UserID):
+		return h.onTier(ctx, entities, user, args)
 	}
 
 	if !twitter.IsValidTwitterURL(m.Message) {
 		return nil
 	}
 
-	return h.onTwitterURLFromUser(ctx, entities, user, m)
+	return h.onTwitterURLFromUser(ctx, entities, user, m.Message, h.processTweetURL)
 }
 
 func (h *Handler) onStart(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
-	msg := "Отправь ссылку на пост в твиттер и я скачаю фото или видео.\nSend me a link to a tweet and I will download the photo or video."
+	msg := "Отправь ссылку на пост в твиттер и я скачаю фото или видео.\nSend me a link to a tweet and I will download the photo or video.\n\n/audio <ссылка> - звук из видео. /audio <link> - the sound of the video.\n/collage - несколько фото одним изображением. /collage - several photos as one image.\n/card - твиты без медиа картинкой. /card - tweets without media as an image."
 	if _, err := h.sendText(ctx, user, msg); err != nil {
 		h.Logger.Error("failed to send message", zap.Error(err))
 	}
 	return nil
 }
+func (h *Handler) onStatus(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+	if _, err := h.sendTextf(ctx, user, "X circuit breaker: %s", h.breaker.State()); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+	return nil
+}
+
 func (h *Handler) sendTextf(ctx context.Context, user *tg.PeerUser, format string, args ...interface{}) (*tg.Message, error) {
 	return h.sendText(ctx, user, fmt.Sprintf(format, args...))
 }
@@ -134,10 +287,28 @@ func (h *Handler) sendText(ctx context.Context, user *tg.PeerUser, text string)
 	return unpack.Message(h.sender.To(h.inputUser(user)).Text(ctx, text))
 }
 
+// sends a message to the admin if one is set
+func (h *Handler) notifyAdmin(ctx context.Context, text string) {
+	if h.adminID == 0 {
+		return
+	}
+
+	if _, err := h.sender.To(h.inputUserAdmin()).Text(ctx, text); err != nil {
+		h.Logger.Error("failed to notify admin", zap.Error(err))
+	}
+}
+
 func (h *Handler) replyErrorf(ctx context.Context, user *tg.PeerUser, err error, format string, args ...interface{}) {
 	h.replyError(ctx, user, err, fmt.Sprintf(format, args...))
 }
 
 func (h *Handler) replyError(ctx context.Context, user *tg.PeerUser, err error, msg string) {
+	// cancelled by the user
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		msg += " Превышено время ожидания. Timed out."
+	}
 	_, _ = h.sendText(ctx, user, msg+" Попробуйте отправить ещё раз. Try again.")
 }
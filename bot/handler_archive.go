@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/go-faster/errors"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+func mediaMeta(td *twitter.TweetData, d Downloaded) MediaMeta {
+	meta := MediaMeta{
+		TweetID:     td.Url.ID,
+		TweetURL:    td.Url.String(),
+		Author:      td.Author(),
+		Text:        td.TweetText(),
+		MediaKey:    d.MediaKey,
+		SourceURL:   d.Entity.URL(),
+		ContentType: mediaContentType(path.Ext(d.Name)),
+		Size:        d.Size,
+	}
+
+	meta.Date, _ = twitter.SnowflakeTime(td.Url.ID)
+
+	switch {
+	case d.IsPhoto():
+		meta.Kind = "photo"
+	case d.IsVideo():
+		meta.Kind = "video"
+	}
+
+	return meta
+}
+
+// puts the downloaded files to the media store. Failures don't fail the request
+func (h *Handler) archiveDownloads(ctx context.Context, td *twitter.TweetData, downloads []Downloaded) {
+	if h.store == nil {
+		return
+	}
+
+	ctx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	defer cancel()
+
+	for _, d := range downloads {
+		if d.Path == "" {
+			continue
+		}
+
+		if err := h.archiveDownload(ctx, td, d); err != nil {
+			h.Logger.Error("failed to archive media", zap.String("key", d.Key), zap.Error(err))
+		}
+	}
+}
+
+func (h *Handler) archiveDownload(ctx context.Context, td *twitter.TweetData, d Downloaded) error {
+	exists, err := h.store.Exists(ctx, d.Key)
+
+	if err != nil {
+		return errors.Wrap(err, "exists")
+	}
+
+	if exists {
+		return nil
+	}
+
+	f, err := os.Open(d.Path)
+
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+
+	defer f.Close()
+
+	if err := h.store.Put(ctx, d.Key, f, d.Size, mediaMeta(td, d)); err != nil {
+		return errors.Wrap(err, "put")
+	}
+
+	h.Logger.Info("Archived media", zap.String("key", d.Key))
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/mp4"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+// longer titles are cut
+const audioTitleMaxLen = 64
+
+// /audio <url> sends the sound of the tweet videos as audio files
+func (h *Handler) onAudio(ctx context.Context, entities tg.Entities, user *tg.PeerUser, args []string) error {
+	if len(args) != 1 || !twitter.IsValidTwitterURL(args[0]) {
+		_, err := h.sendText(ctx, user, "Использование: /audio <ссылка на твит>. Usage: /audio <tweet link>.")
+		if err != nil {
+			h.Logger.Error("failed to send message", zap.Error(err))
+		}
+		return nil
+	}
+
+	return h.onTwitterURLFromUser(ctx, entities, user, args[0], h.processTweetAudio)
+}
+
+// the first line of the tweet text or the tweet id
+func audioTitle(td *twitter.TweetData) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(td.CleanText()), "\n")
+	title = strings.TrimSpace(title)
+
+	if title == "" {
+		return "Tweet " + td.Url.ID
+	}
+
+	if utf8.RuneCountInString(title) > audioTitleMaxLen {
+		title = string([]rune(title)[:audioTitleMaxLen-1]) + "…"
+	}
+
+	return title
+}
+
+// fetches the tweet, downloads its videos and sends their audio tracks. Runs in the job queue
+func (h *Handler) processTweetAudio(ctx context.Context, user *tg.PeerUser, url string) error {
+	ctx = h.withWaitNotify(ctx, user)
+
+	td, err := h.fetchTweet(ctx, user, url)
+
+	if td == nil {
+		return err
+	}
+
+	if !td.HasVideos() {
+		_, err := h.sendText(ctx, user, "В твите нет видео. The tweet has no video.")
+		if err != nil {
+			h.Logger.Error("failed to send message", zap.Error(err))
+		}
+		return nil
+	}
+
+	// only the videos are downloaded
+	videos := &twitter.TweetData{Url: td.Url, FullText: td.FullText, Text: td.Text, Videos: td.Videos}
+
+	selectCtx, cancel := withTimeout(ctx, h.timeouts.Download)
+	selected, oversized := h.downloader.SelectVideoVariants(selectCtx, videos, h.variantLimits)
+	cancel()
+
+	if len(oversized) > 0 {
+		h.sendOversizedLinks(ctx, user, oversized)
+	}
+
+	if selected.NoMedia() {
+		return nil
+	}
+
+	status := jobStatusFrom(ctx)
+	status.setStage(stageDownload, len(selected.Videos), true)
+
+	downloadCtx, cancel := withTimeout(ctx, h.timeouts.Download)
+	downloadCtx = WithDownloadProgress(downloadCtx, status.setProgress)
+	downloads, release, err := h.downloader.DownloadTweetData(downloadCtx, selected, h.downloadFolder)
+	cancel()
+
+	if err != nil {
+		h.Logger.Error("failed to download tweet data", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка cкачки. Error downloading.")
+		return errors.Wrap(err, "download tweet data")
+	}
+
+	defer release()
+
+	paths := downloadPaths(downloads)
+
+	var (
+		audios     []audioFile
+		audioPaths []string
+		audioSize  int64
+	)
+
+	for _, d := range downloads {
+		if !d.IsVideo() || d.Video.Silent {
+			continue
+		}
+
+		audio, err := extractAudio(d)
+
+		if errors.Is(err, mp4.ErrNoAudio) {
+			h.Logger.Info("no audio track", zap.String("path", d.Path), zap.Error(err))
+			continue
+		}
+
+		if err != nil {
+			h.Logger.Error("failed to extract audio", zap.String("path", d.Path), zap.Error(err))
+			h.replyError(ctx, user, err, "Ошибка извлечения звука. Error extracting audio.")
+			return errors.Wrap(err, "extract audio")
+		}
+
+		audios = append(audios, audio)
+		audioPaths = append(audioPaths, audio.Path)
+		audioSize += audio.Size
+	}
+
+	h.addTransfer(user.UserID, totalSize(downloads), 0)
+
+	if len(audios) == 0 {
+		release()
+		h.sweeper.Done(paths...)
+
+		_, err := h.sendText(ctx, user, "В видео нет звука. The video has no sound.")
+		if err != nil {
+			h.Logger.Error("failed to send message", zap.Error(err))
+		}
+		return nil
+	}
+
+	releaseAudio := h.sweeper.Hold(audioPaths...)
+	defer releaseAudio()
+
+	uploadCtx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	defer cancel()
+
+	if err := h.sendAudios(uploadCtx, user, td, audios); err != nil {
+		h.Logger.Error("send audio", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка закачки в телеграм. Error uploading to telegram.")
+		return errors.Wrap(err, "send audio")
+	}
+
+	h.addTransfer(user.UserID, 0, audioSize)
+
+	release()
+	releaseAudio()
+	h.sweeper.Done(append(paths, audioPaths...)...)
+
+	return nil
+}
+
+// audio track extracted from a downloaded video
+type audioFile struct {
+	Path     string
+	Name     string
+	Size     int64
+	Duration time.Duration
+}
+
+// writes the audio track of the downloaded video next to it
+func extractAudio(d Downloaded) (audioFile, error) {
+	path := strings.TrimSuffix(d.Path, filepath.Ext(d.Path)) + ".m4a"
+
+	if err := mp4.ExtractAudioFile(d.Path, path); err != nil {
+		return audioFile{}, err
+	}
+
+	info, err := mp4.ReadFile(path)
+	if err != nil {
+		return audioFile{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return audioFile{}, errors.Wrap(err, "stat")
+	}
+
+	return audioFile{
+		Path:     path,
+		Name:     strings.TrimSuffix(d.Name, filepath.Ext(d.Name)) + ".m4a",
+		Size:     stat.Size(),
+		Duration: info.Duration,
+	}, nil
+}
+
+// uploads the audio files and sends each of them with the title of the tweet
+func (h *Handler) sendAudios(ctx context.Context, user *tg.PeerUser, td *twitter.TweetData, audios []audioFile) error {
+	uploader, _ := h.uploaderWithSender()
+
+	status := jobStatusFrom(ctx)
+	status.setStage(stageUpload, len(audios), false)
+
+	for i, audio := range audios {
+		status.setProgress(i, 0, audio.Size)
+	}
+
+	title := audioTitle(td)
+	performer, _ := tweetAuthor(td)
+
+	for i, audio := range audios {
+		h.Logger.Info("Uploading audio", zap.String("path", audio.Path))
+
+		i := i
+		uploader.WithProgress(uploadProgress(func(done, total int64) {
+			status.setProgress(i, done, total)
+		}))
+
+		u, err := uploader.FromPath(ctx, audio.Path)
+		if err != nil {
+			return errors.Wrap(err, "upload audio")
+		}
+
+		doc := message.UploadedDocument(u, styling.Plain(h.makeMessageText(td))).
+			Filename(audio.Name).
+			MIME("audio/mp4").
+			Audio().
+			Title(title).
+			Performer(performer)
+
+		if audio.Duration > 0 {
+			doc = doc.Duration(audio.Duration)
+		}
+
+		if _, err := h.sender.To(h.inputUser(user)).Media(ctx, doc); err != nil {
+			return errors.Wrap(err, "send audio")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/message/unpack"
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+// returns false if the user gets text-only tweets as text
+func (h *Handler) userCardTheme(userID int64) (CardTheme, bool) {
+	data, ok, err := h.users.Get(userID)
+
+	if err != nil {
+		h.Logger.Error("failed to get user", zap.Int64("user", userID), zap.Error(err))
+	}
+
+	if !ok || data.Card == "" {
+		return CardTheme{}, false
+	}
+
+	theme, err := ParseCardTheme(data.Card)
+
+	if err != nil {
+		h.Logger.Warn("bad card theme", zap.Int64("user", userID), zap.Error(err))
+		return CardTheme{}, false
+	}
+
+	return theme, true
+}
+
+// /card [light|dark|off] sends text-only tweets as images
+func (h *Handler) onCard(ctx context.Context, entities tg.Entities, user *tg.PeerUser, args []string) error {
+	if len(args) > 0 && args[0] != "off" {
+		if _, err := ParseCardTheme(args[0]); err != nil {
+			_, err := h.sendText(ctx, user, "Использование: /card [light|dark|off]. Usage: /card [light|dark|off].")
+			if err != nil {
+				h.Logger.Error("failed to send message", zap.Error(err))
+			}
+			return nil
+		}
+	}
+
+	data, err := h.users.Update(user.UserID, func(data *UserData) {
+		switch {
+		case len(args) > 0 && args[0] == "off":
+			data.Card = ""
+		case len(args) > 0:
+			data.Card = args[0]
+		case data.Card == "":
+			data.Card = CardThemeLight.Name
+		default:
+			data.Card = ""
+		}
+	})
+
+	if err != nil {
+		h.Logger.Error("failed to update user", zap.Int64("user", user.UserID), zap.Error(err))
+		h.replyErrorf(ctx, user, err, "Ошибка сохранения настройки. Error saving the setting.")
+		return nil
+	}
+
+	msg := "Твиты без медиа отправляются текстом. Tweets without media are sent as text."
+	if data.Card != "" {
+		msg = fmt.Sprintf("Твиты без медиа отправляются картинкой (%s). Tweets without media are sent as an image (%s).", data.Card, data.Card)
+	}
+
+	if _, err := h.sendText(ctx, user, msg); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
+
+// renders the tweet card and sends it to the peer as a photo. Only the cards
+// sent to the user are accounted
+func (h *Handler) sendCard(ctx context.Context, user *tg.PeerUser, peer tg.InputPeerClass, td *twitter.TweetData, theme CardTheme) (*tg.Message, error) {
+	data, err := RenderCardPNG(td, theme, h.card)
+	if err != nil {
+		return nil, errors.Wrap(err, "render card")
+	}
+
+	uploadCtx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	defer cancel()
+
+	u, err := h.uploader().FromBytes(uploadCtx, "card.png", data)
+	if err != nil {
+		return nil, errors.Wrap(err, "upload card")
+	}
+
+	if isUserPeer(peer, user) {
+		h.addTransfer(user.UserID, 0, int64(len(data)))
+	}
+
+	// the text is on the card
+	caption := h.makeCaption(td, false)
+
+	msg, err := unpack.Message(h.sender.To(peer).Media(uploadCtx, message.UploadedPhoto(u, styling.Plain(caption))))
+	if err != nil {
+		return nil, errors.Wrap(err, "send card")
+	}
+
+	return msg, nil
+}
+
+// sends the tweet without media as a text or as a card. The channel gets a card
+// if it's set for it
+func (h *Handler) sendTextTweet(ctx context.Context, user *tg.PeerUser, td *twitter.TweetData, messageText string) error {
+	theme, card := h.userCardTheme(user.UserID)
+
+	var (
+		sent *tg.Message
+		err  error
+	)
+
+	if card {
+		if sent, err = h.sendCard(ctx, user, h.inputUser(user), td, theme); err != nil {
+			h.Logger.Error("send card", zap.Error(err))
+			h.replyError(ctx, user, err, "Ошибка отправки картинки твита. Error sending the tweet image.")
+			return err
+		}
+	} else if sent, err = h.sendText(ctx, user, messageText); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+		return nil
+	}
+
+	if h.forwardTo == 0 || h.forwardCard == "" {
+		return nil
+	}
+
+	if card && theme.Name == h.forwardCard {
+		return h.forwardMessages(ctx, user, []*tg.Message{sent})
+	}
+
+	forwardTheme, err := ParseCardTheme(h.forwardCard)
+	if err != nil {
+		return err
+	}
+
+	h.Logger.Info("Sending card to channel", zap.Int64("channel", h.forwardTo))
+
+	if _, err := h.sendCard(ctx, user, h.inputChannelPeer(), td, forwardTheme); err != nil {
+		h.Logger.Error("send card to channel", zap.Error(err))
+		return errors.Wrap(err, "send card to channel")
+	}
+
+	return nil
+}
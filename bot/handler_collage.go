@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/message/unpack"
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) userCollage(userID int64) bool {
+	data, ok, err := h.users.Get(userID)
+
+	if err != nil {
+		h.Logger.Error("failed to get user", zap.Int64("user", userID), zap.Error(err))
+	}
+
+	return ok && data.Collage
+}
+
+// /collage [on|off] switches between an album and a collage for tweets with several photos
+func (h *Handler) onCollage(ctx context.Context, entities tg.Entities, user *tg.PeerUser, args []string) error {
+	var (
+		enabled bool
+		err     error
+	)
+
+	_, err = h.users.Update(user.UserID, func(data *UserData) {
+		switch {
+		case len(args) > 0 && args[0] == "on":
+			data.Collage = true
+		case len(args) > 0 && args[0] == "off":
+			data.Collage = false
+		default:
+			data.Collage = !data.Collage
+		}
+		enabled = data.Collage
+	})
+
+	if err != nil {
+		h.Logger.Error("failed to update user", zap.Int64("user", user.UserID), zap.Error(err))
+		h.replyErrorf(ctx, user, err, "Ошибка сохранения настройки. Error saving the setting.")
+		return nil
+	}
+
+	msg := "Несколько фото отправляются альбомом. Several photos are sent as an album."
+	if enabled {
+		msg = "Несколько фото отправляются одним коллажем. Several photos are sent as one collage."
+	}
+
+	if _, err := h.sendText(ctx, user, msg); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
+
+// downloads the tweet photos and sends them to the peer as one collage. The transfer
+// is accounted and the media archived only when the peer is the user
+func (h *Handler) sendCollage(ctx context.Context, user *tg.PeerUser, peer tg.InputPeerClass, td *twitter.TweetData, caption string) ([]*tg.Message, error) {
+	toUser := isUserPeer(peer, user)
+
+	status := jobStatusFrom(ctx)
+	status.setStage(stageDownload, len(td.Photos), false)
+
+	downloadCtx, cancel := withTimeout(ctx, h.timeouts.Download)
+	downloadCtx = WithDownloadProgress(downloadCtx, status.setProgress)
+	downloads, release, err := h.downloader.DownloadTweetData(downloadCtx, td, h.downloadFolder)
+	cancel()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "download tweet data")
+	}
+
+	defer release()
+
+	paths := downloadPaths(downloads)
+
+	first := downloads[0].Path
+	path := strings.TrimSuffix(first, filepath.Ext(first)) + "_collage.jpg"
+
+	releaseCollage := h.sweeper.Hold(path)
+	defer releaseCollage()
+
+	if err := makeCollageFile(paths, path, h.collage); err != nil {
+		return nil, errors.Wrap(err, "make collage")
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat collage")
+	}
+
+	h.Logger.Info("Sending collage", zap.Int("photos", len(downloads)), zap.Int64("size", stat.Size()))
+
+	status.setStage(stageUpload, 1, false)
+	status.setProgress(0, 0, stat.Size())
+
+	uploadCtx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	defer cancel()
+
+	up := h.uploader().WithProgress(uploadProgress(func(done, total int64) {
+		status.setProgress(0, done, total)
+	}))
+
+	u, err := up.FromPath(uploadCtx, path)
+
+	if err != nil {
+		if toUser {
+			h.addTransfer(user.UserID, totalSize(downloads), 0)
+		}
+		return nil, errors.Wrap(err, "upload collage")
+	}
+
+	if toUser {
+		h.addTransfer(user.UserID, totalSize(downloads), stat.Size())
+	}
+
+	msg, err := unpack.Message(h.sender.To(peer).Media(uploadCtx, message.UploadedPhoto(u, styling.Plain(caption))))
+
+	if err != nil {
+		return nil, errors.Wrap(err, "send collage")
+	}
+
+	if toUser {
+		h.archiveDownloads(ctx, td, downloads)
+	}
+
+	release()
+	releaseCollage()
+	h.sweeper.Done(append(paths, path)...)
+
+	return []*tg.Message{msg}, nil
+}
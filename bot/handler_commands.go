@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// shows the user tier and the remaining quota
+func (h *Handler) onMe(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+	data, ok, err := h.users.Get(user.UserID)
+
+	if err != nil || !ok {
+		h.Logger.Error("No user data", zap.Int64("user", user.UserID), zap.Error(err))
+		h.replyErrorf(ctx, user, err, "Ошибка получения данных пользователя. Error getting user data.")
+		return nil
+	}
+
+	now := h.nowFunc()
+	tier := h.userTier(data)
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Тариф: %s. Tier: %s\n", tier.Name, tier.Name)
+
+	if tier.Unlimited {
+		sb.WriteString("Без ограничений. No limits\n")
+	}
+
+	for _, ws := range h.quota.Status(tier, data.Quota, now) {
+		left := ws.Window.Format(max(ws.Remaining, 0)) + "/" + ws.Window.Format(ws.Window.Limit)
+		fmt.Fprintf(&sb, "%s: осталось %s. %s left", ws.Window.Name, left, left)
+
+		if ws.Exhausted() {
+			next := formatUntil(ws.NextAt, now)
+			fmt.Fprintf(&sb, ", следующий через %s. Next in %s", next, next)
+		}
+
+		if !ws.ResetAt.IsZero() {
+			reset := formatUntil(ws.ResetAt, now)
+			fmt.Fprintf(&sb, ", полный сброс через %s. Full reset in %s", reset, reset)
+		}
+
+		sb.WriteString("\n")
+	}
+
+	today := data.TransferOn(now.UTC().Format(transferDayLayout))
+
+	downloaded, uploaded := formatBytes(today.Downloaded), formatBytes(today.Uploaded)
+
+	fmt.Fprintf(&sb, "Сегодня скачано %s, загружено %s. Today: downloaded %s, uploaded %s\n", downloaded, uploaded, downloaded, uploaded)
+	fmt.Fprintf(&sb, "Всего запросов: %d. Total requests: %d", data.QueriesTotal, data.QueriesTotal)
+
+	if _, err := h.sendText(ctx, user, sb.String()); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
+
+// /tier <user id> <tier> assigns the tier to the user
+func (h *Handler) onTier(ctx context.Context, entities tg.Entities, user *tg.PeerUser, args []string) error {
+	tiers := h.quota.TierNames()
+	sort.Strings(tiers)
+
+	if len(args) != 2 {
+		_, _ = h.sendTextf(ctx, user, "Usage: /tier <user id> <%s>", strings.Join(tiers, "|"))
+		return nil
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+
+	if err != nil {
+		_, _ = h.sendTextf(ctx, user, "Invalid user id: %s", args[0])
+		return nil
+	}
+
+	if !h.quota.HasTier(args[1]) {
+		_, _ = h.sendTextf(ctx, user, "Unknown tier %s. Tiers: %s", args[1], strings.Join(tiers, ", "))
+		return nil
+	}
+
+	if err := h.setUserTier(userID, args[1]); err != nil {
+		h.Logger.Error("failed to set tier", zap.Int64("user", userID), zap.Error(err))
+		h.replyErrorf(ctx, user, err, "Failed to set tier.")
+		return nil
+	}
+
+	h.Logger.Info("Tier set", zap.Int64("user", userID), zap.String("tier", args[1]))
+
+	if _, err := h.sendTextf(ctx, user, "User %d tier: %s", userID, args[1]); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
+
+// number of users listed in /stats
+const statsTopUsers = 10
+
+// shows transfer accounting of all users
+func (h *Handler) onStats(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+	users, err := h.users.All()
+
+	if err != nil {
+		h.Logger.Error("failed to list users", zap.Error(err))
+		h.replyErrorf(ctx, user, err, "Failed to list users.")
+		return nil
+	}
+
+	day := h.nowFunc().UTC().Format(transferDayLayout)
+
+	var todayTotal DailyTransfer
+	var totalDownloaded, totalUploaded int64
+	var active []*UserData
+
+	for _, u := range users {
+		t := u.TransferOn(day)
+		todayTotal.Downloaded += t.Downloaded
+		todayTotal.Uploaded += t.Uploaded
+		totalDownloaded += u.BytesDownloaded
+		totalUploaded += u.BytesUploaded
+
+		if t.Downloaded+t.Uploaded > 0 {
+			active = append(active, u)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		ti, tj := active[i].TransferOn(day), active[j].TransferOn(day)
+		return ti.Downloaded+ti.Uploaded > tj.Downloaded+tj.Uploaded
+	})
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Users: %d\n", len(users))
+	fmt.Fprintf(&sb, "Today (%s UTC): downloaded %s, uploaded %s, active users %d\n",
+		day, formatBytes(todayTotal.Downloaded), formatBytes(todayTotal.Uploaded), len(active))
+	fmt.Fprintf(&sb, "Total: downloaded %s, uploaded %s\n", formatBytes(totalDownloaded), formatBytes(totalUploaded))
+
+	if len(active) > statsTopUsers {
+		active = active[:statsTopUsers]
+	}
+
+	for _, u := range active {
+		t := u.TransferOn(day)
+		fmt.Fprintf(&sb, "%d @%s [%s]: %s / %s, %d requests\n",
+			u.UserID, u.Username, h.userTier(u).Name, formatBytes(t.Downloaded), formatBytes(t.Uploaded), u.QueriesTotal)
+	}
+
+	if _, err := h.sendText(ctx, user, sb.String()); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
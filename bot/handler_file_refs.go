@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+var errNoFileRefs = errors.New("no file refs")
+
+func isStaleFileRef(err error) bool {
+	return tgerr.Is(err,
+		tg.ErrFileReferenceExpired,
+		tg.ErrFileReferenceInvalid,
+		tg.ErrFileReferenceEmpty,
+	)
+}
+
+// sends the tweet media using references to the files uploaded before.
+// Returns errNoFileRefs if some of the media was not uploaded yet
+func (h *Handler) sendFromFileRefs(ctx context.Context, peer tg.InputPeerClass, td *twitter.TweetData, caption string) ([]*tg.Message, error) {
+	if h.fileRefs == nil {
+		return nil, errNoFileRefs
+	}
+
+	mediaList := tweetMediaList(td)
+
+	if len(mediaList) == 0 {
+		return nil, errNoFileRefs
+	}
+
+	album := make([]message.MultiMediaOption, len(mediaList))
+
+	for i, m := range mediaList {
+		ref, ok := h.fileRefs.Get(td.Url.ID, m.MediaKey)
+
+		if !ok {
+			return nil, errNoFileRefs
+		}
+
+		st := []styling.StyledTextOption{}
+
+		if i == 0 {
+			st = []styling.StyledTextOption{styling.Plain(caption)}
+		}
+
+		switch ref.Kind {
+		case FileRefPhoto:
+			album[i] = message.Photo(ref, st...)
+		case FileRefDocument:
+			album[i] = message.Document(ref, st...)
+		default:
+			return nil, errNoFileRefs
+		}
+	}
+
+	h.Logger.Info("Sending album from file refs", zap.Int("count", len(album)))
+
+	sentMsgs, err := UnpackMultipleMessages(h.sender.To(peer).
+		Album(ctx, album[0], album[1:]...))
+
+	if err != nil && isStaleFileRef(err) {
+		h.Logger.Info("Stale file refs", zap.String("tweet", td.Url.ID), zap.Error(err))
+		h.deleteFileRefs(td, mediaList)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "send album from file refs")
+	}
+
+	return sentMsgs, nil
+}
+
+func (h *Handler) deleteFileRefs(td *twitter.TweetData, mediaList []tweetMedia) {
+	keys := make([]string, len(mediaList))
+
+	for i, m := range mediaList {
+		keys[i] = m.MediaKey
+	}
+
+	if err := h.fileRefs.Delete(td.Url.ID, keys...); err != nil {
+		h.Logger.Error("failed to delete file refs", zap.Error(err))
+	}
+}
+
+// records the references to the uploaded files of the sent album
+func (h *Handler) saveFileRefs(td *twitter.TweetData, downloads []Downloaded, sentMsgs []*tg.Message) {
+	if h.fileRefs == nil {
+		return
+	}
+
+	if len(sentMsgs) != len(downloads) {
+		h.Logger.Warn("Sent messages count mismatch", zap.Int("sent", len(sentMsgs)), zap.Int("downloads", len(downloads)))
+		return
+	}
+
+	// album messages get ids in the order of the media
+	msgs := make([]*tg.Message, len(sentMsgs))
+	copy(msgs, sentMsgs)
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+
+	refs := make(map[string]FileRef, len(downloads))
+
+	for i, d := range downloads {
+		ref, ok := fileRefFromMessage(msgs[i])
+		if !ok {
+			h.Logger.Warn("No media in sent message", zap.Int("id", msgs[i].ID))
+			return
+		}
+		refs[d.MediaKey] = ref
+	}
+
+	if err := h.fileRefs.Set(td.Url.ID, refs); err != nil {
+		h.Logger.Error("failed to save file refs", zap.Error(err))
+	}
+}
@@ -25,6 +25,12 @@ func (h *Handler) inputUser(user *tg.PeerUser) tg.InputPeerClass {
 	}
 }
 
+// false for the channel copies which are not accounted to the user
+func isUserPeer(peer tg.InputPeerClass, user *tg.PeerUser) bool {
+	p, ok := peer.(*tg.InputPeerUser)
+	return ok && p.UserID == user.UserID
+}
+
 func (h *Handler) inputUserAdmin() tg.InputPeerClass {
 	return &tg.InputPeerUser{
 		UserID:     h.adminID,
@@ -37,3 +43,20 @@ func (h *Handler) inputChannelPeer() tg.InputPeerClass {
 		AccessHash: h.uploadToAccessHash,
 	}
 }
+
+func (h *Handler) editText(ctx context.Context, user *tg.PeerUser, m *tg.Message, text string) error {
+	_, err := h.sender.To(h.inputUser(user)).Edit(m.ID).Text(ctx, text)
+	return err
+}
+
+// setTyping shows the action like uploading a video in the chat with the user
+func (h *Handler) setTyping(ctx context.Context, user *tg.PeerUser, action tg.SendMessageActionClass) {
+	_, err := h.api.MessagesSetTyping(ctx, &tg.MessagesSetTypingRequest{
+		Peer:   h.inputUser(user),
+		Action: action,
+	})
+
+	if err != nil {
+		h.Logger.Debug("failed to set typing", zap.Error(err))
+	}
+}
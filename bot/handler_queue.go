@@ -0,0 +1,386 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// status edits are throttled to stay under the flood limits. Chat actions
+// last about five seconds so they are sent as often
+const statusEditInterval = 4 * time.Second
+
+type jobStage int
+
+const (
+	stageQueued jobStage = iota
+	stageFetch
+	stageDownload
+	stageUpload
+)
+
+type mediaProgress struct {
+	done  int64
+	total int64
+}
+
+// jobStatus is the message showing the position of a queued job. It's edited through
+// the stages once the job is started and removed when it's finished
+type jobStatus struct {
+	h    *Handler
+	user *tg.PeerUser
+
+	// serializes the message edits
+	editLock sync.Mutex
+
+	mu    sync.Mutex
+	msg   *tg.Message
+	pos   int
+	shown string
+	done  bool
+	stage jobStage
+	media []mediaProgress
+	// selects the chat action
+	video bool
+	stop  chan struct{}
+	// pending edit of the queue position
+	positionTimer *time.Timer
+}
+
+type jobStatusKey struct{}
+
+func withJobStatus(ctx context.Context, s *jobStatus) context.Context {
+	return context.WithValue(ctx, jobStatusKey{}, s)
+}
+
+// returns nil if the request is not a job, the methods of nil status do nothing
+func jobStatusFrom(ctx context.Context) *jobStatus {
+	s, _ := ctx.Value(jobStatusKey{}).(*jobStatus)
+	return s
+}
+
+// overall percent of the media with known sizes
+func (s *jobStatus) percentLocked() int {
+	var done, total int64
+
+	for _, m := range s.media {
+		if m.total > 0 {
+			done += min(m.done, m.total)
+			total += m.total
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return int(done * 100 / total)
+}
+
+func (s *jobStatus) textLocked() string {
+	switch s.stage {
+	case stageFetch:
+		return "Получаю твит... Fetching the tweet..."
+	case stageDownload:
+		current := 1
+		for _, m := range s.media {
+			if m.total > 0 && m.done >= m.total {
+				current++
+			}
+		}
+		current = min(current, len(s.media))
+		pct := s.percentLocked()
+		return fmt.Sprintf("Скачиваю %d/%d, %d%%... Downloading %d/%d, %d%%...",
+			current, len(s.media), pct, current, len(s.media), pct)
+	case stageUpload:
+		pct := s.percentLocked()
+		return fmt.Sprintf("Загружаю в телеграм %d%%... Uploading to telegram %d%%...", pct, pct)
+	}
+	return fmt.Sprintf("Вы #%d в очереди. You are #%d in queue.", s.pos, s.pos)
+}
+
+// sends the status message. Called after the job is pushed
+func (s *jobStatus) send(ctx context.Context) {
+	s.editLock.Lock()
+	defer s.editLock.Unlock()
+
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	text := s.textLocked()
+	s.mu.Unlock()
+
+	msg, err := s.h.sendText(ctx, s.user, text)
+
+	if err != nil {
+		s.h.Logger.Error("failed to send message", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	s.msg = msg
+	s.shown = text
+	s.mu.Unlock()
+}
+
+// called by the queue while the job is waiting. The position changes made
+// within statusEditInterval are shown with one edit
+func (s *jobStatus) setPosition(pos int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pos = pos
+
+	if s.done || s.positionTimer != nil {
+		return
+	}
+
+	s.positionTimer = time.AfterFunc(statusEditInterval, func() {
+		s.mu.Lock()
+		s.positionTimer = nil
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		s.flush(ctx)
+	})
+}
+
+// edits the message to the latest text
+func (s *jobStatus) flush(ctx context.Context) {
+	s.editLock.Lock()
+	defer s.editLock.Unlock()
+
+	s.mu.Lock()
+	msg, text := s.msg, s.textLocked()
+	skip := s.done || msg == nil || text == s.shown
+	s.mu.Unlock()
+
+	if skip {
+		return
+	}
+
+	if err := s.h.editText(ctx, s.user, msg, text); err != nil {
+		s.h.Logger.Error("failed to edit message", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	s.shown = text
+	s.mu.Unlock()
+}
+
+// setStage starts a stage processing count media
+func (s *jobStatus) setStage(stage jobStage, count int, video bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stage = stage
+	s.media = make([]mediaProgress, count)
+	s.video = video
+}
+
+// setProgress updates the progress of the index-th media of the stage
+func (s *jobStatus) setProgress(index int, done, total int64) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index >= 0 && index < len(s.media) {
+		s.media[index] = mediaProgress{done: done, total: total}
+	}
+}
+
+// the chat action of the current stage, nil if none
+func (s *jobStatus) action() tg.SendMessageActionClass {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stage != stageDownload && s.stage != stageUpload {
+		return nil
+	}
+
+	pct := s.percentLocked()
+
+	if s.video {
+		return &tg.SendMessageUploadVideoAction{Progress: pct}
+	}
+
+	return &tg.SendMessageUploadPhotoAction{Progress: pct}
+}
+
+func (s *jobStatus) start(ctx context.Context) {
+	s.mu.Lock()
+	s.stage = stageFetch
+	s.stop = make(chan struct{})
+	s.mu.Unlock()
+
+	s.flush(ctx)
+
+	go s.run(ctx, s.stop)
+}
+
+// shows the progress until the job is finished. Chat actions expire in a few
+// seconds so they are repeated with every tick
+func (s *jobStatus) run(ctx context.Context, stop chan struct{}) {
+	ticker := time.NewTicker(statusEditInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if action := s.action(); action != nil {
+			s.h.setTyping(ctx, s.user, action)
+		}
+
+		s.flush(ctx)
+	}
+}
+
+// removes the message when the job is finished
+func (s *jobStatus) finish(ctx context.Context) {
+	s.editLock.Lock()
+	defer s.editLock.Unlock()
+
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	msg := s.msg
+	if s.stop != nil {
+		close(s.stop)
+	}
+	if s.positionTimer != nil {
+		s.positionTimer.Stop()
+	}
+	s.mu.Unlock()
+
+	if msg != nil {
+		s.h.removeMessage(ctx, msg)
+	}
+}
+
+// enqueues the tweet url request of the user
+func (h *Handler) enqueueTweetURL(ctx context.Context, user *tg.PeerUser, url string, process tweetProcessor) {
+	status := &jobStatus{h: h, user: user}
+
+	h.incrPending(user.UserID)
+
+	// the slot is freed by /cancel even if the job is stuck
+	releasePending := sync.OnceFunc(func() {
+		h.decrPending(user.UserID)
+	})
+
+	h.jobs.Push(&Job{
+		UserID:     user.UserID,
+		Priority:   h.isAdmin(user.UserID),
+		OnPosition: status.setPosition,
+		OnCancel: func() {
+			releasePending()
+
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				status.finish(ctx)
+			}()
+		},
+		Run: func(ctx context.Context) {
+			defer releasePending()
+			// the message is removed even if the job is cancelled
+			defer status.finish(context.WithoutCancel(ctx))
+
+			status.start(ctx)
+
+			// the media of a request share the job bandwidth
+			err := process(withJobStatus(h.downloader.JobContext(ctx), status), user, url)
+
+			if errors.Is(err, context.Canceled) {
+				h.Logger.Info("job cancelled", zap.Int64("user", user.UserID), zap.String("url", url))
+				return
+			}
+
+			if err != nil {
+				h.Logger.Error("failed to process tweet", zap.Int64("user", user.UserID), zap.String("url", url), zap.Error(err))
+			}
+		},
+	})
+
+	status.send(ctx)
+}
+
+// cancels the waiting and running requests of the user
+func (h *Handler) onCancel(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+	n := h.jobs.Cancel(user.UserID)
+
+	h.Logger.Info("Cancel", zap.Int64("user", user.UserID), zap.Int("cancelled", n))
+
+	var err error
+
+	if n == 0 {
+		_, err = h.sendText(ctx, user, "Нет активных запросов. No active requests.")
+	} else {
+		_, err = h.sendTextf(ctx, user, "Отменено запросов: %d. Cancelled requests: %d.", n, n)
+	}
+
+	if err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
+
+func (h *Handler) onQueue(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+	state := h.jobs.State()
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Queue: %d waiting, %d running, %d workers\n", state.Queued, state.Running, state.Workers)
+
+	if positions := state.UserPositions[user.UserID]; len(positions) > 0 {
+		sb.WriteString("Your requests in queue:")
+		for _, pos := range positions {
+			fmt.Fprintf(&sb, " #%d", pos)
+		}
+		sb.WriteString("\n")
+	}
+
+	if running := state.UserRunning[user.UserID]; running > 0 {
+		fmt.Fprintf(&sb, "Your running requests: %d\n", running)
+	}
+
+	if h.isAdmin(user.UserID) && len(state.UserPositions) > 0 {
+		sb.WriteString("Waiting by user:\n")
+		for userID, positions := range state.UserPositions {
+			fmt.Fprintf(&sb, "%d: %d\n", userID, len(positions))
+		}
+	}
+
+	if _, err := h.sendText(ctx, user, sb.String()); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+
+	return nil
+}
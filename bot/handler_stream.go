@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+var errUnknownSize = errors.New("unknown size")
+
+// streams the tweet media from X to telegram without saving it. Media of unknown size
+// and media which upload failed are spooled to temporary files in the download folder
+func (h *Handler) streamAndSend(ctx context.Context, user *tg.PeerUser, td *twitter.TweetData, messageText string) ([]*tg.Message, error) {
+	// streaming is both downloading and uploading
+	timeout := h.timeouts.Download + h.timeouts.Upload
+	if h.timeouts.Download <= 0 || h.timeouts.Upload <= 0 {
+		timeout = 0
+	}
+
+	streamCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	up := h.uploader()
+	media := tweetMediaList(td)
+	downloads := make([]Downloaded, len(media))
+	files := make([]tg.InputFileClass, len(media))
+
+	status := jobStatusFrom(ctx)
+	status.setStage(stageUpload, len(media), len(td.Videos) > 0)
+
+	names, err := h.downloader.Filenames(td, media)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "filename")
+	}
+
+	for i, m := range media {
+		name := names[i]
+		downloads[i] = Downloaded{Key: name, Name: path.Base(name), MediaKey: m.MediaKey, Entity: m.Entity, Video: m.Video, PosterURL: m.PosterURL}
+
+		i := i
+		up.WithProgress(uploadProgress(func(done, total int64) {
+			status.setProgress(i, done, total)
+		}))
+
+		u, size, err := h.streamUpload(streamCtx, up, m.Entity.URL(), downloads[i].Name)
+
+		if err != nil && streamCtx.Err() == nil && !errors.Is(err, errBadContent) {
+			h.Logger.Warn("Streaming failed, spooling to disk", zap.String("name", downloads[i].Name), zap.Error(err))
+			u, size, err = h.spoolUpload(streamCtx, up, m.Entity.URL(), downloads[i].Name)
+		}
+
+		if err != nil {
+			h.addTransfer(user.UserID, totalSize(downloads), 0)
+			h.Logger.Error("stream media", zap.Error(err))
+			h.replyError(ctx, user, err, "Ошибка закачки в телеграм. Error uploading to telegram.")
+			return nil, errors.Wrap(err, "stream media")
+		}
+
+		files[i] = u
+		downloads[i].Size = size
+	}
+
+	h.addTransfer(user.UserID, totalSize(downloads), totalSize(downloads))
+
+	uploads, err := albumMedia(downloads, files, h.uploadThumbs(streamCtx, downloads), messageText)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "album media")
+	}
+
+	h.Logger.Info("Sending album", zap.Int("count", len(uploads)))
+
+	sentMsgs, err := UnpackMultipleMessages(h.sender.To(h.inputUser(user)).
+		Album(streamCtx, uploads[0], uploads[1:]...))
+
+	if err != nil {
+		h.Logger.Error("send media group", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка отправки медигруппы в телеграм. Error sending media group.")
+		return nil, errors.Wrap(err, "send media group")
+	}
+
+	h.saveFileRefs(td, downloads, sentMsgs)
+
+	return sentMsgs, nil
+}
+
+// uploads the body of the url as it's being downloaded
+func (h *Handler) streamUpload(ctx context.Context, up *uploader.Uploader, url, name string) (tg.InputFileClass, int64, error) {
+	stream, err := h.downloader.Open(ctx, url)
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "open")
+	}
+
+	defer stream.Close()
+
+	if stream.Size < 0 {
+		return nil, 0, errUnknownSize
+	}
+
+	br := bufio.NewReader(stream)
+
+	// a short body is caught by the uploader
+	header, _ := br.Peek(16)
+
+	if err := validateMagic(header, path.Ext(name)); err != nil {
+		return nil, 0, err
+	}
+
+	h.Logger.Info("Streaming media", zap.String("name", name), zap.Int64("size", stream.Size))
+
+	u, err := up.Upload(ctx, uploader.NewUpload(name, br, stream.Size))
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "upload")
+	}
+
+	return u, stream.Size, nil
+}
+
+// downloads the url to a temporary file of its own and uploads it as name. The file
+// is removed after, the media saved by other requests is not touched
+func (h *Handler) spoolUpload(ctx context.Context, up *uploader.Uploader, url, name string) (tg.InputFileClass, int64, error) {
+	ext := path.Ext(name)
+
+	// the extension is kept for the content validation
+	f, err := os.CreateTemp(h.downloadFolder, strings.TrimSuffix(name, ext)+".*"+ext)
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "create spool file")
+	}
+
+	spool := f.Name()
+	f.Close()
+
+	defer os.Remove(spool)
+
+	if err := h.downloader.Download(ctx, url, spool); err != nil {
+		return nil, 0, errors.Wrap(err, "download")
+	}
+
+	f, err = os.Open(spool)
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "open spool file")
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "stat")
+	}
+
+	u, err := up.Upload(ctx, uploader.NewUpload(name, f, stat.Size()))
+
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "upload")
+	}
+
+	return u, stat.Size(), nil
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram/message"
+	"github.com/gotd/td/tg"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeUploads accepts the uploaded file parts and fails the other calls like sending messages
+type fakeUploads struct {
+	mu    sync.Mutex
+	bytes int
+	calls int
+}
+
+func (f *fakeUploads) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+
+	switch req := input.(type) {
+	case *tg.UploadSaveFilePartRequest:
+		f.bytes += len(req.Bytes)
+	case *tg.UploadSaveBigFilePartRequest:
+		f.bytes += len(req.Bytes)
+	default:
+		return errors.Errorf("unexpected %T", input)
+	}
+
+	output.(*tg.BoolBox).Bool = &tg.BoolTrue{}
+
+	return nil
+}
+
+func newStreamTestHandler(t *testing.T, uploads *fakeUploads) *Handler {
+	quota, err := NewQuota(QuotaSlidingWindow, []Tier{{Name: TierDefault, Unlimited: true}})
+	require.NoError(t, err)
+
+	api := tg.NewClient(uploads)
+
+	d := NewDownloader()
+	d.MinWait = time.Millisecond
+	d.MaxWait = time.Millisecond
+
+	return &Handler{
+		Logger:         zap.NewNop(),
+		api:            api,
+		sender:         message.NewSender(api),
+		downloader:     d,
+		downloadFolder: t.TempDir(),
+		users:          NewUserStorageMemory(),
+		quota:          quota,
+		nowFunc:        time.Now,
+	}
+}
+
+func TestStreamUpload(t *testing.T) {
+	content := append(magicJPEG, bytes.Repeat([]byte("x"), 1000)...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page.jpg":
+			_, _ = w.Write([]byte("<!DOCTYPE html><html></html>"))
+		case "/chunked.jpg":
+			// no content length
+			w.(http.Flusher).Flush()
+			_, _ = w.Write(content)
+		default:
+			_, _ = w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	uploads := &fakeUploads{}
+	h := newStreamTestHandler(t, uploads)
+	ctx := context.Background()
+
+	u, size, err := h.streamUpload(ctx, h.uploader(), server.URL+"/photo.jpg", "photo.jpg")
+	require.NoError(t, err)
+	require.NotNil(t, u)
+	require.Equal(t, int64(len(content)), size)
+	require.Equal(t, len(content), uploads.bytes)
+
+	_, _, err = h.streamUpload(ctx, h.uploader(), server.URL+"/chunked.jpg", "photo.jpg")
+	require.ErrorIs(t, err, errUnknownSize)
+
+	_, _, err = h.streamUpload(ctx, h.uploader(), server.URL+"/page.jpg", "photo.jpg")
+	require.ErrorIs(t, err, errBadContent)
+	require.Equal(t, len(content), uploads.bytes)
+}
+
+func TestSpoolUpload(t *testing.T) {
+	content := append(magicJPEG, bytes.Repeat([]byte("x"), 1000)...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	uploads := &fakeUploads{}
+	h := newStreamTestHandler(t, uploads)
+
+	// media of the same name saved by another request
+	saved := path.Join(h.downloadFolder, "photo.jpg")
+	require.NoError(t, os.WriteFile(saved, magicJPEG, 0644))
+
+	u, size, err := h.spoolUpload(context.Background(), h.uploader(), server.URL+"/photo.jpg", "photo.jpg")
+	require.NoError(t, err)
+	require.NotNil(t, u)
+	require.Equal(t, int64(len(content)), size)
+	require.Equal(t, len(content), uploads.bytes)
+
+	// only the spool file is removed
+	files, err := filepath.Glob(path.Join(h.downloadFolder, "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{saved}, files)
+}
+
+func TestStreamAndSendFallback(t *testing.T) {
+	content := append(magicJPEG, bytes.Repeat([]byte("x"), 1000)...)
+
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/page.jpg" {
+			_, _ = w.Write([]byte("<!DOCTYPE html><html></html>"))
+			return
+		}
+		// unknown size is spooled
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	uploads := &fakeUploads{}
+	h := newStreamTestHandler(t, uploads)
+
+	td := &twitter.TweetData{
+		Url:    twitter.TwitterURL{User: "user", ID: "1"},
+		Photos: []twitter.Photo{{MediaKey: "a", MediaURLHttps: server.URL + "/page.jpg"}},
+	}
+
+	_, err := h.streamAndSend(context.Background(), &tg.PeerUser{UserID: 1}, td, "")
+	require.ErrorIs(t, err, errBadContent)
+
+	// bad content is not spooled to disk, the only call is the error reply
+	require.Equal(t, 1, requests)
+	require.Equal(t, 0, uploads.bytes)
+	require.Equal(t, 1, uploads.calls)
+
+	entries, err := os.ReadDir(h.downloadFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	requests = 0
+	td.Photos[0].MediaURLHttps = server.URL + "/photo.jpg"
+
+	// the fake fails sending the album
+	_, err = h.streamAndSend(context.Background(), &tg.PeerUser{UserID: 1}, td, "")
+	require.Error(t, err)
+	require.Equal(t, 2, requests)
+	require.Equal(t, len(content), uploads.bytes)
+
+	// the spooled file is removed
+	files, err := filepath.Glob(path.Join(h.downloadFolder, "*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
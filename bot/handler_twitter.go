@@ -2,6 +2,10 @@ package bot
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/tg"
@@ -10,8 +14,12 @@ import (
 )
 
 func (h *Handler) makeMessageText(td *twitter.TweetData) string {
+	return h.makeCaption(td, h.IncludeText)
+}
+
+func (h *Handler) makeCaption(td *twitter.TweetData, includeText bool) string {
 	messageText := ""
-	if h.IncludeText && td.CleanText() != "" {
+	if includeText && td.CleanText() != "" {
 		messageText += td.TweetText() + "\n"
 	}
 	if h.IncludeURL {
@@ -23,17 +31,59 @@ func (h *Handler) makeMessageText(td *twitter.TweetData) string {
 	return messageText
 }
 
-func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities, user *tg.PeerUser, m *tg.Message) error {
+// called with the breaker lock held so the notification is sent in background
+func (h *Handler) onBreakerStateChange(from, to twitter.BreakerState) {
+	h.Logger.Warn("Circuit breaker state changed", zap.Stringer("from", from), zap.Stringer("to", to))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		h.notifyAdmin(ctx, fmt.Sprintf("X circuit breaker: %s -> %s", from, to))
+	}()
+}
+
+func (h *Handler) replyUnavailable(ctx context.Context, user *tg.PeerUser) {
+	_, err := h.sendText(ctx, user, "X сейчас недоступен, попробуйте позже. X is currently unavailable, try again later.")
+	if err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+}
+
+// tells the user once per request that X requests are being delayed by the rate limiter
+func (h *Handler) withWaitNotify(ctx context.Context, user *tg.PeerUser) context.Context {
+	var once sync.Once
+
+	return twitter.WithWaitNotify(ctx, func(wait time.Duration) {
+		once.Do(func() {
+			secs := int(wait.Round(time.Second).Seconds())
+			_, err := h.sendTextf(ctx, user, "Слишком много запросов к X, ожидание ~%d сек. Too many requests to X, waiting ~%d sec.", secs, secs)
+			if err != nil {
+				h.Logger.Error("failed to send message", zap.Error(err))
+			}
+		})
+	})
+}
 
-	h.Logger.Info("Received url", zap.String("url", m.Message))
+// processes the tweet url in the job queue
+type tweetProcessor func(ctx context.Context, user *tg.PeerUser, url string) error
 
-	h.updateQueryCountLimit(user.UserID)
+func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities, user *tg.PeerUser, url string, process tweetProcessor) error {
 
-	cq, cqr := h.canQuery(user.UserID)
+	h.Logger.Info("Received url", zap.String("url", url))
+
+	if !h.twitter.Available() && !h.twitter.IsCached(url) {
+		h.Logger.Info("Twitter is unavailable", zap.Int64("user", user.UserID))
+		h.replyUnavailable(ctx, user)
+		return nil
+	}
+
+	cq, cqr, ws := h.canQuery(user.UserID)
 
 	if !cq && cqr == reasonLimit {
-		h.Logger.Info("Limit exceeded", zap.Int64("user", user.UserID))
-		_, err := h.sendTextf(ctx, user, "Превышен лимит запросов в день (%d). Exceeded the limit of requests per day (%d).", h.limitPerDay, h.limitPerDay)
+		h.Logger.Info("Limit exceeded", zap.Int64("user", user.UserID), zap.String("window", ws.Window.Name))
+		next := formatUntil(ws.NextAt, h.nowFunc())
+		_, err := h.sendTextf(ctx, user, "Превышен лимит (%s), следующий запрос через %s. Exceeded the limit (%s), next request in %s.",
+			ws.Window, next, ws.Window, next)
 
 		if err != nil {
 			h.Logger.Error("failed to send message", zap.Error(err))
@@ -59,34 +109,50 @@ func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities
 		return nil
 	}
 
-	h.incrPending(user.UserID)
 	h.incrQueries(user.UserID)
+	h.enqueueTweetURL(ctx, user, url, process)
 
-	defer h.decrPending(user.UserID)
-
-	var workingMessage *tg.Message
-	var err error
-	peer := h.inputUser(user)
+	return nil
+}
 
-	if workingMessage, err = h.sendText(ctx, user, "Работаю... Working..."); err != nil {
-		h.Logger.Error("failed to send message", zap.Error(err))
-		return nil
+// fetches the tweet data and replies to the user if it fails. Returns nil data
+// if there is nothing to send
+func (h *Handler) fetchTweet(ctx context.Context, user *tg.PeerUser, url string) (*twitter.TweetData, error) {
+	fetchCtx, cancel := withTimeout(ctx, h.timeouts.Fetch)
+	td, err := h.twitter.GetTwitterData(fetchCtx, url)
+	cancel()
+
+	if errors.Is(err, twitter.ErrUnavailable) {
+		h.Logger.Info("Twitter is unavailable", zap.Int64("user", user.UserID))
+		h.replyUnavailable(ctx, user)
+		return nil, nil
 	}
 
-	defer h.removeMessage(ctx, workingMessage)
-
-	td, err := h.twitter.GetTwitterData(ctx, m.Message)
-
 	if err != nil {
 		h.Logger.Error("failed to get twitter data", zap.Error(err))
 		h.replyError(ctx, user, err, "Ошибка получения данных из твиттера. Error getting data from twitter.")
-		return errors.Wrap(err, "get twitter data")
+		return nil, errors.Wrap(err, "get twitter data")
 	}
 
 	if td.IsEmpty() {
 		h.Logger.Info("empty tweet data")
 		h.replyError(ctx, user, nil, "Не удалось получить данные из твиттера. Failed to get data from twitter.")
-		return nil
+		return nil, nil
+	}
+
+	return td, nil
+}
+
+// fetches the tweet and sends its media to the user. Runs in the job queue
+func (h *Handler) processTweetURL(ctx context.Context, user *tg.PeerUser, url string) error {
+	peer := h.inputUser(user)
+
+	ctx = h.withWaitNotify(ctx, user)
+
+	td, err := h.fetchTweet(ctx, user, url)
+
+	if td == nil {
+		return err
 	}
 
 	h.Logger.Debug("twitter data", zap.Any("data", td))
@@ -94,44 +160,46 @@ func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities
 	messageText := h.makeMessageText(td)
 
 	if td.NoMedia() {
-		_, err := h.sendText(ctx, user, messageText)
-		if err != nil {
-			h.Logger.Error("failed to send message", zap.Error(err))
-		}
-		return nil
+		return h.sendTextTweet(ctx, user, td, messageText)
 	}
 
-	downloads, err := h.downloader.DownloadTweetData(td, h.downloadFolder)
+	collage := canCollage(td) && h.userCollage(user.UserID)
 
-	if err != nil {
-		h.Logger.Error("failed to download tweet data", zap.Error(err))
-		h.replyError(ctx, user, err, "Ошибка cкачки. Error downloading.")
-		return errors.Wrap(err, "download tweet data")
-	}
+	var sentMsgs []*tg.Message
 
-	h.Logger.Info("Sending album", zap.Int("count", len(downloads)))
+	if collage {
+		sentMsgs, err = h.sendCollage(ctx, user, peer, td, messageText)
 
-	uploads, err := h.uploadDownloads(ctx, downloads, messageText)
+		if err != nil {
+			h.Logger.Error("send collage", zap.Error(err))
+			h.replyError(ctx, user, err, "Ошибка отправки коллажа. Error sending the collage.")
+			return err
+		}
+	} else if sentMsgs, err = h.sendTweetMedia(ctx, user, td, messageText); err != nil {
+		return err
+	}
 
-	if err != nil {
-		h.Logger.Error("upload files", zap.Error(err))
-		h.replyError(ctx, user, err, "Ошибка закачки в телеграм. Error uploading to telegram.")
-		return errors.Wrap(err, "upload files")
+	if h.forwardTo == 0 || len(sentMsgs) == 0 {
+		return nil
 	}
 
-	sentMsgs, err := UnpackMultipleMessages(h.sender.To(peer).
-		Album(ctx, uploads[0], uploads[1:]...))
+	// the channel gets the collage even if the user got the album
+	if h.forwardCollage && !collage && canCollage(td) {
+		h.Logger.Info("Sending collage to channel", zap.Int64("channel", h.forwardTo))
 
-	if err != nil {
-		h.Logger.Error("send media group", zap.Error(err))
-		h.replyError(ctx, user, err, "Ошибка отправки медигруппы в телеграм. Error sending media group.")
-		return errors.Wrap(err, "send media group")
-	}
+		if _, err := h.sendCollage(ctx, user, h.inputChannelPeer(), td, messageText); err != nil {
+			h.Logger.Error("send collage to channel", zap.Error(err))
+			return errors.Wrap(err, "send collage to channel")
+		}
 
-	if h.forwardTo == 0 {
 		return nil
 	}
 
+	return h.forwardMessages(ctx, user, sentMsgs)
+}
+
+// forwards the messages sent to the user to the channel
+func (h *Handler) forwardMessages(ctx context.Context, user *tg.PeerUser, sentMsgs []*tg.Message) error {
 	sentMsgsIDs := make([]int, len(sentMsgs))
 
 	for i, sentMsg := range sentMsgs {
@@ -140,7 +208,7 @@ func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities
 
 	h.Logger.Info("Forwarding to channel", zap.Int64("channel", h.forwardTo))
 
-	_, err = h.sender.To(h.inputChannelPeer()).
+	_, err := h.sender.To(h.inputChannelPeer()).
 		ForwardIDs(h.inputUser(user), sentMsgsIDs[0], sentMsgsIDs[1:]...).
 		Send(ctx)
 
@@ -151,3 +219,111 @@ func (h *Handler) onTwitterURLFromUser(ctx context.Context, entities tg.Entities
 
 	return nil
 }
+
+// sends the tweet media from the file refs or downloads it. Returns no messages
+// if no media fits the limits
+func (h *Handler) sendTweetMedia(ctx context.Context, user *tg.PeerUser, td *twitter.TweetData, messageText string) ([]*tg.Message, error) {
+	uploadCtx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	sentMsgs, err := h.sendFromFileRefs(uploadCtx, h.inputUser(user), td, messageText)
+	cancel()
+
+	if err == nil {
+		return sentMsgs, nil
+	}
+
+	if !errors.Is(err, errNoFileRefs) {
+		h.Logger.Error("failed to send from file refs", zap.Error(err))
+	}
+
+	selectCtx, cancel := withTimeout(ctx, h.timeouts.Download)
+	selected, oversized := h.downloader.SelectVideoVariants(selectCtx, td, h.variantLimits)
+	cancel()
+
+	if len(oversized) > 0 {
+		h.sendOversizedLinks(ctx, user, oversized)
+	}
+
+	if selected.NoMedia() {
+		return nil, nil
+	}
+
+	send := h.downloadAndSend
+	if h.streaming {
+		send = h.streamAndSend
+	}
+
+	return send(ctx, user, selected, messageText)
+}
+
+// sends links to the videos that can't be sent
+func (h *Handler) sendOversizedLinks(ctx context.Context, user *tg.PeerUser, oversized []OversizedVideo) {
+	var sb strings.Builder
+
+	sb.WriteString("Видео превышает ограничения, ссылки для скачивания. The video exceeds the limits, download links:\n")
+
+	for _, ov := range oversized {
+		fmt.Fprintf(&sb, "\nBest (%s):\n%s\n", ov.Best, ov.Best.URL())
+
+		if ov.Smaller.URL() != ov.Best.URL() {
+			fmt.Fprintf(&sb, "Smaller (%s):\n%s\n", ov.Smaller, ov.Smaller.URL())
+		}
+	}
+
+	if _, err := h.sendText(ctx, user, sb.String()); err != nil {
+		h.Logger.Error("failed to send message", zap.Error(err))
+	}
+}
+
+// downloads the tweet media from X and sends it as an album
+func (h *Handler) downloadAndSend(ctx context.Context, user *tg.PeerUser, td *twitter.TweetData, messageText string) ([]*tg.Message, error) {
+	status := jobStatusFrom(ctx)
+	status.setStage(stageDownload, len(tweetMediaList(td)), len(td.Videos) > 0)
+
+	downloadCtx, cancel := withTimeout(ctx, h.timeouts.Download)
+	downloadCtx = WithDownloadProgress(downloadCtx, status.setProgress)
+	downloads, release, err := h.downloader.DownloadTweetData(downloadCtx, td, h.downloadFolder)
+	cancel()
+
+	if err != nil {
+		h.Logger.Error("failed to download tweet data", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка cкачки. Error downloading.")
+		return nil, errors.Wrap(err, "download tweet data")
+	}
+
+	defer release()
+
+	paths := downloadPaths(downloads)
+
+	h.Logger.Info("Sending album", zap.Int("count", len(downloads)))
+
+	uploadCtx, cancel := withTimeout(ctx, h.timeouts.Upload)
+	defer cancel()
+
+	uploads, err := h.uploadDownloads(uploadCtx, downloads, messageText)
+
+	if err != nil {
+		h.addTransfer(user.UserID, totalSize(downloads), 0)
+		h.Logger.Error("upload files", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка закачки в телеграм. Error uploading to telegram.")
+		return nil, errors.Wrap(err, "upload files")
+	}
+
+	h.addTransfer(user.UserID, totalSize(downloads), totalSize(downloads))
+
+	sentMsgs, err := UnpackMultipleMessages(h.sender.To(h.inputUser(user)).
+		Album(uploadCtx, uploads[0], uploads[1:]...))
+
+	if err != nil {
+		h.Logger.Error("send media group", zap.Error(err))
+		h.replyError(ctx, user, err, "Ошибка отправки медигруппы в телеграм. Error sending media group.")
+		return nil, errors.Wrap(err, "send media group")
+	}
+
+	h.saveFileRefs(td, downloads, sentMsgs)
+	h.archiveDownloads(ctx, td, downloads)
+
+	release()
+	h.sweeper.Done(paths...)
+
+	return sentMsgs, nil
+}
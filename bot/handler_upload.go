@@ -2,12 +2,12 @@ package bot
 
 import (
 	"context"
-	"path"
 
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 )
 
@@ -24,16 +24,106 @@ func (h *Handler) uploaderWithSender() (*uploader.Uploader, *message.Sender) {
 func (h *Handler) uploadDownloads(ctx context.Context, downloads []Downloaded, caption string) ([]message.MultiMediaOption, error) {
 
 	uploader, _ := h.uploaderWithSender()
-	uploads := make([]message.MultiMediaOption, len(downloads))
+	files := make([]tg.InputFileClass, len(downloads))
+
+	status := jobStatusFrom(ctx)
+	status.setStage(stageUpload, len(downloads), hasVideo(downloads))
+
+	for i, download := range downloads {
+		status.setProgress(i, 0, download.Size)
+	}
 
 	for i, download := range downloads {
 		h.Logger.Info("Uploading media", zap.String("path", download.Path))
 
+		i := i
+		uploader.WithProgress(uploadProgress(func(done, total int64) {
+			status.setProgress(i, done, total)
+		}))
+
 		u, err := uploader.FromPath(ctx, download.Path)
 		if err != nil {
 			return nil, errors.Wrap(err, "upload media")
 		}
 
+		files[i] = u
+	}
+
+	return albumMedia(downloads, files, h.uploadThumbs(ctx, downloads), caption)
+}
+
+// uploads the thumbnails of the videos made from their posters. Videos without
+// posters or which thumbnails failed get nil
+func (h *Handler) uploadThumbs(ctx context.Context, downloads []Downloaded) []tg.InputFileClass {
+	// no progress of the media
+	up := h.uploader()
+	thumbs := make([]tg.InputFileClass, len(downloads))
+
+	for i, download := range downloads {
+		if !download.IsVideo() || download.PosterURL == "" {
+			continue
+		}
+
+		data, err := h.downloader.Thumbnail(ctx, download.PosterURL)
+
+		if err != nil {
+			h.Logger.Warn("failed to make thumbnail", zap.String("url", download.PosterURL), zap.Error(err))
+			continue
+		}
+
+		if thumbs[i], err = up.FromBytes(ctx, "thumb.jpg", data); err != nil {
+			h.Logger.Warn("failed to upload thumbnail", zap.Error(err))
+		}
+	}
+
+	return thumbs
+}
+
+func hasVideo(downloads []Downloaded) bool {
+	for _, d := range downloads {
+		if d.IsVideo() {
+			return true
+		}
+	}
+	return false
+}
+
+// a streamable video, albums of photos and such videos are allowed. A single
+// silent video is sent as an animation which can't be in albums
+func videoMedia(u, thumb tg.InputFileClass, download Downloaded, single bool, caption ...styling.StyledTextOption) *message.VideoDocumentBuilder {
+	doc := message.UploadedDocument(u, caption...).Filename(download.Name)
+
+	if thumb != nil {
+		doc = doc.Thumb(thumb)
+	}
+
+	if download.Video.Silent {
+		if single {
+			doc = doc.GIF()
+		} else {
+			doc = doc.NosoundVideo(true)
+		}
+	}
+
+	video := doc.MIME("video/mp4").Video().SupportsStreaming()
+
+	if a := download.Video; a.Width > 0 && a.Height > 0 {
+		video = video.Resolution(a.Width, a.Height)
+	}
+
+	if download.Video.Duration > 0 {
+		video = video.Duration(download.Video.Duration)
+	}
+
+	return video
+}
+
+// makes the album of the uploaded files
+func albumMedia(downloads []Downloaded, files, thumbs []tg.InputFileClass, caption string) ([]message.MultiMediaOption, error) {
+	uploads := make([]message.MultiMediaOption, len(downloads))
+
+	for i, download := range downloads {
+		u := files[i]
 		st := []styling.StyledTextOption{}
 
 		if i == 0 {
@@ -46,10 +136,7 @@ func (h *Handler) uploadDownloads(ctx context.Context, downloads []Downloaded, c
 		if download.IsPhoto() {
 			uploads[i] = message.UploadedPhoto(u, st...)
 		} else if download.IsVideo() {
-			doc := message.UploadedDocument(u, st...)
-			doc.Filename(path.Base(download.Path))
-			doc.MIME("video/mp4")
-			uploads[i] = doc
+			uploads[i] = videoMedia(u, thumbs[i], download, len(downloads) == 1, st...)
 		} else {
 			return nil, errors.New("unsupported media type")
 		}
@@ -1,12 +1,54 @@
 package bot
 
-import "time"
+import (
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
 
 type UserData struct {
-	UserID         int64
-	QueriesToday   int
-	LastQueryTime  time.Time
-	PendingQueires int
+	UserID        int64
+	Username      string
+	FirstName     string
+	LangCode      string
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	QueriesTotal  int
+	LastQueryTime time.Time
+	// empty means default tier
+	Tier  string
+	Quota QuotaState
+	// several photos are sent as one collage
+	Collage bool
+	// theme of the images of tweets without media, empty to send them as text
+	Card string
+
+	BytesDownloaded int64
+	BytesUploaded   int64
+	// last days of transfer accounting
+	Transfers []DailyTransfer
+}
+
+// DailyTransfer is bytes transferred during a UTC day
+type DailyTransfer struct {
+	Day        string
+	Downloaded int64
+	Uploaded   int64
+}
+
+// number of days kept in UserData.Transfers
+const transferDays = 30
+
+const transferDayLayout = "2006-01-02"
+
+func (data *UserData) TransferOn(day string) DailyTransfer {
+	for _, t := range data.Transfers {
+		if t.Day == day {
+			return t
+		}
+	}
+	return DailyTransfer{Day: day}
 }
 
 func (h *Handler) isAdmin(userID int64) bool {
@@ -17,35 +59,107 @@ func (h *Handler) adminRestricted() bool {
 	return h.adminID != 0 && h.restrictToAdminID
 }
 
-// create a new user data if not exists
-func (h *Handler) initUser(userID int64) {
-	h.usersMapLock.Lock()
-	defer h.usersMapLock.Unlock()
+// create a new user data if not exists and update the profile
+func (h *Handler) initUser(userID int64, entities tg.Entities) {
+	now := h.nowFunc()
+
+	_, err := h.users.Update(userID, func(data *UserData) {
+		if data.FirstSeen.IsZero() {
+			data.FirstSeen = now
+		}
+
+		data.LastSeen = now
 
-	if _, ok := h.usersMap[userID]; !ok {
-		h.usersMap[userID] = &UserData{
-			UserID: userID,
+		if u, ok := entities.Users[userID]; ok {
+			data.Username = u.Username
+			data.FirstName = u.FirstName
+			data.LangCode = u.LangCode
 		}
+	})
+
+	if err != nil {
+		h.Logger.Error("failed to init user", zap.Int64("user", userID), zap.Error(err))
 	}
 }
 
+// pending requests are not persisted so a restart doesn't leave users blocked
 func (h *Handler) incrPending(userID int64) {
-	h.usersMapLock.Lock()
-	defer h.usersMapLock.Unlock()
-	h.usersMap[userID].PendingQueires++
+	h.pendingLock.Lock()
+	defer h.pendingLock.Unlock()
+	h.pending[userID]++
 }
 
 func (h *Handler) decrPending(userID int64) {
-	h.usersMapLock.Lock()
-	defer h.usersMapLock.Unlock()
-	h.usersMap[userID].PendingQueires--
+	h.pendingLock.Lock()
+	defer h.pendingLock.Unlock()
+	h.pending[userID]--
+	if h.pending[userID] <= 0 {
+		delete(h.pending, userID)
+	}
+}
+
+func (h *Handler) pendingCount(userID int64) int {
+	h.pendingLock.Lock()
+	defer h.pendingLock.Unlock()
+	return h.pending[userID]
+}
+
+// userTier returns the tier of the user. Admin always gets admin tier
+func (h *Handler) userTier(data *UserData) Tier {
+	if h.isAdmin(data.UserID) {
+		return h.quota.Tier(TierAdmin)
+	}
+	return h.quota.Tier(data.Tier)
 }
 
 func (h *Handler) incrQueries(userID int64) {
-	h.usersMapLock.Lock()
-	defer h.usersMapLock.Unlock()
-	h.usersMap[userID].QueriesToday++
-	h.usersMap[userID].LastQueryTime = h.nowFunc()
+	now := h.nowFunc()
+
+	_, err := h.users.Update(userID, func(data *UserData) {
+		data.QueriesTotal++
+		data.LastQueryTime = now
+		h.quota.Consume(h.userTier(data), &data.Quota, now)
+	})
+
+	if err != nil {
+		h.Logger.Error("failed to update user", zap.Int64("user", userID), zap.Error(err))
+	}
+}
+
+// records bytes downloaded from X and uploaded to telegram for the user
+func (h *Handler) addTransfer(userID int64, downloaded, uploaded int64) {
+	now := h.nowFunc()
+	day := now.UTC().Format(transferDayLayout)
+
+	_, err := h.users.Update(userID, func(data *UserData) {
+		data.BytesDownloaded += downloaded
+		data.BytesUploaded += uploaded
+
+		if n := len(data.Transfers); n == 0 || data.Transfers[n-1].Day != day {
+			data.Transfers = append(data.Transfers, DailyTransfer{Day: day})
+		}
+
+		today := &data.Transfers[len(data.Transfers)-1]
+		today.Downloaded += downloaded
+		today.Uploaded += uploaded
+
+		if len(data.Transfers) > transferDays {
+			data.Transfers = data.Transfers[len(data.Transfers)-transferDays:]
+		}
+
+		h.quota.ConsumeBytes(h.userTier(data), &data.Quota, now, downloaded+uploaded)
+	})
+
+	if err != nil {
+		h.Logger.Error("failed to update user", zap.Int64("user", userID), zap.Error(err))
+	}
+}
+
+func (h *Handler) setUserTier(userID int64, tier string) error {
+	_, err := h.users.Update(userID, func(data *UserData) {
+		data.Tier = tier
+	})
+	return err
 }
 
 type reason string
@@ -56,42 +170,31 @@ const (
 	reasonPending reason = "pending"
 )
 
-// check if QueriesToday is need to be reset
-func (h *Handler) updateQueryCountLimit(userID int64) {
-	h.usersMapLock.Lock()
-	defer h.usersMapLock.Unlock()
-
-	data, ok := h.usersMap[userID]
-	if !ok {
-		return
-	}
-
-	if data.LastQueryTime.Day() != h.nowFunc().Day() {
-		data.QueriesToday = 0
+// returns the exhausted window with reasonLimit
+func (h *Handler) canQuery(userID int64) (bool, reason, WindowStatus) {
+	// admin can query without limits
+	if h.isAdmin(userID) {
+		return true, "", WindowStatus{}
 	}
-}
 
-// admin can query without limits
-func (h *Handler) canQuery(userID int64) (bool, reason) {
-	h.usersMapLock.RLock()
-	defer h.usersMapLock.RUnlock()
+	data, ok, err := h.users.Get(userID)
 
-	if h.isAdmin(userID) {
-		return true, ""
+	if err != nil {
+		h.Logger.Error("failed to get user", zap.Int64("user", userID), zap.Error(err))
+		return false, reasonNoUser, WindowStatus{}
 	}
 
-	data, ok := h.usersMap[userID]
 	if !ok {
-		return false, reasonNoUser
+		return false, reasonNoUser, WindowStatus{}
 	}
 
-	if data.QueriesToday >= h.limitPerDay {
-		return false, reasonLimit
+	if ws, ok := h.quota.Allow(h.userTier(data), data.Quota, h.nowFunc()); !ok {
+		return false, reasonLimit, ws
 	}
 
-	if data.PendingQueires >= h.limitPending {
-		return false, reasonPending
+	if h.pendingCount(userID) >= h.limitPending {
+		return false, reasonPending, WindowStatus{}
 	}
 
-	return true, ""
+	return true, "", WindowStatus{}
 }
@@ -1,6 +1,12 @@
 package bot
 
 import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"time"
+
 	"github.com/go-faster/errors"
 	"github.com/gotd/td/tg"
 )
@@ -39,3 +45,63 @@ func UnpackMultipleMessages(update tg.UpdatesClass, err error) ([]*tg.Message, e
 
 	return messages, nil
 }
+
+// writes the file to a temporary file in the same folder and renames it
+// so the file is either old or new after a crash
+func writeFileAtomic(filepath string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(path.Dir(filepath), path.Base(filepath)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "write temp file")
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "sync temp file")
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "close temp file")
+	}
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "chmod temp file")
+	}
+
+	if err := os.Rename(tmp, filepath); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "rename temp file")
+	}
+
+	return nil
+}
+
+// zero timeout means no deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// splits "/cmd@botname arg1 arg2" into "/cmd" and args. Returns empty cmd for non commands
+func parseCommand(text string) (string, []string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", nil
+	}
+
+	fields := strings.Fields(text)
+	cmd, _, _ := strings.Cut(fields[0], "@")
+
+	return cmd, fields[1:]
+}
@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+var errBadContent = errors.New("unexpected file content")
+
+var magicJPEG = []byte{0xFF, 0xD8, 0xFF}
+var magicPNG = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// checks the file content matches the extension so an error page isn't sent as media
+func validateMagic(header []byte, ext string) error {
+	var ok bool
+
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		ok = bytes.HasPrefix(header, magicJPEG)
+	case ".png":
+		ok = bytes.HasPrefix(header, magicPNG)
+	case ".gif":
+		ok = bytes.HasPrefix(header, []byte("GIF8"))
+	case ".webp":
+		ok = len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP"
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		ok = len(header) >= 8 && string(header[4:8]) == "ftyp"
+	default:
+		// unknown type, only reject markup
+		trimmed := bytes.ToLower(bytes.TrimSpace(header))
+		ok = !bytes.HasPrefix(trimmed, []byte("<!doctype")) && !bytes.HasPrefix(trimmed, []byte("<html"))
+	}
+
+	if !ok {
+		return errors.Wrapf(errBadContent, "not a %s file", ext)
+	}
+
+	return nil
+}
+
+// validates the file at path as a file with the extension of name
+func validateFileMagic(path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(f, header)
+
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "read")
+	}
+
+	return validateMagic(header[:n], filepath.Ext(name))
+}
@@ -1,6 +1,11 @@
 package bot
 
-import "go.uber.org/zap"
+import (
+	"time"
+
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
 
 type options struct {
 	logger        *zap.Logger
@@ -19,6 +24,51 @@ type options struct {
 
 	limitPerDay  int
 	limitPending int
+
+	twitterRateLimits twitter.RateLimits
+	breakerSettings   twitter.BreakerSettings
+
+	useCache     bool
+	cacheOptions twitter.CacheOptions
+
+	fileRefsFile string
+
+	userStorageKind UserStorageKind
+	userStorageFile string
+
+	limitPerMinute int
+	limitPerHour   int
+	limitPerWeek   int
+	bytesPerDay    int64
+	quotaAlgorithm QuotaAlgorithm
+	tiers          []Tier
+
+	workers  int
+	timeouts StageTimeouts
+
+	downloadsPerJob int
+	downloadsGlobal int
+
+	streaming bool
+
+	variantLimits VariantLimits
+
+	collage        CollageOptions
+	forwardCollage bool
+
+	card        CardOptions
+	forwardCard string
+
+	retention RetentionOptions
+
+	filenameTemplate *FilenameTemplate
+
+	mediaStore MediaStoreOptions
+
+	dedup bool
+
+	transport twitter.TransportOptions
+	bandwidth BandwidthLimits
 }
 
 type option func(*options)
@@ -74,3 +124,209 @@ func WithLimits(limitPerDay, limitPending int) option {
 		opts.limitPending = limitPending
 	}
 }
+
+// WithTwitterRateLimits sets the budgets for the requests to X
+func WithTwitterRateLimits(limits twitter.RateLimits) option {
+	return func(opts *options) {
+		opts.twitterRateLimits = limits
+	}
+}
+
+// WithBreakerSettings configures the circuit breaker around twitter requests
+func WithBreakerSettings(settings twitter.BreakerSettings) option {
+	return func(opts *options) {
+		opts.breakerSettings = settings
+	}
+}
+
+// WithTweetCache enables caching of tweet data
+func WithTweetCache(useCache bool, cacheOptions twitter.CacheOptions) option {
+	return func(opts *options) {
+		opts.useCache = useCache
+		opts.cacheOptions = cacheOptions
+	}
+}
+
+// WithFileRefsFile sets the file to keep references to uploaded media in.
+// Defaults to file_refs.jsonl in the download folder
+func WithFileRefsFile(fileRefsFile string) option {
+	return func(opts *options) {
+		opts.fileRefsFile = fileRefsFile
+	}
+}
+
+// WithUserStorage sets where the users data is kept.
+// Empty file defaults to users.json or users.db in the download folder
+func WithUserStorage(kind UserStorageKind, file string) option {
+	return func(opts *options) {
+		opts.userStorageKind = kind
+		opts.userStorageFile = file
+	}
+}
+
+// WithWindowLimits adds windows to the default tier besides the limit per day
+func WithWindowLimits(perMinute, perHour, perWeek int) option {
+	return func(opts *options) {
+		opts.limitPerMinute = perMinute
+		opts.limitPerHour = perHour
+		opts.limitPerWeek = perWeek
+	}
+}
+
+// WithBytesLimit limits bytes transferred per day by the default tier
+func WithBytesLimit(bytesPerDay int64) option {
+	return func(opts *options) {
+		opts.bytesPerDay = bytesPerDay
+	}
+}
+
+// WithQuota sets the quota algorithm. The tiers replace the default tiers with the same name
+func WithQuota(algorithm QuotaAlgorithm, tiers ...Tier) option {
+	return func(opts *options) {
+		opts.quotaAlgorithm = algorithm
+		opts.tiers = tiers
+	}
+}
+
+// WithWorkers sets the number of requests processed at the same time
+func WithWorkers(workers int) option {
+	return func(opts *options) {
+		opts.workers = workers
+	}
+}
+
+// WithDownloadConcurrency limits concurrent media downloads of a request and of all requests. Zero means no limit
+func WithDownloadConcurrency(perJob, global int) option {
+	return func(opts *options) {
+		opts.downloadsPerJob = perJob
+		opts.downloadsGlobal = global
+	}
+}
+
+// WithStreaming uploads media to telegram while it's downloaded from X instead of saving it first
+func WithStreaming(streaming bool) option {
+	return func(opts *options) {
+		opts.streaming = streaming
+	}
+}
+
+// WithVariantLimits limits the size and the resolution of the video variant sent
+func WithVariantLimits(limits VariantLimits) option {
+	return func(opts *options) {
+		opts.variantLimits = limits
+	}
+}
+
+// WithCollage sets how the collages of the tweet photos are made
+func WithCollage(collage CollageOptions) option {
+	return func(opts *options) {
+		opts.collage = collage
+	}
+}
+
+// WithForwardCollage sends the photos to the forward channel as a collage
+func WithForwardCollage(enabled bool) option {
+	return func(opts *options) {
+		opts.forwardCollage = enabled
+	}
+}
+
+// WithCard sets the size of the images of tweets without media
+func WithCard(card CardOptions) option {
+	return func(opts *options) {
+		opts.card = card
+	}
+}
+
+// WithForwardCard sends tweets without media to the forward channel as images
+// of the theme, light or dark. Empty theme sends nothing
+func WithForwardCard(theme string) option {
+	return func(opts *options) {
+		opts.forwardCard = theme
+	}
+}
+
+// WithRetention limits the media kept in the download folder
+func WithRetention(retention RetentionOptions) option {
+	return func(opts *options) {
+		opts.retention = retention
+	}
+}
+
+// WithFilenames sets how the media files in the download folder are named
+func WithFilenames(t *FilenameTemplate) option {
+	return func(opts *options) {
+		opts.filenameTemplate = t
+	}
+}
+
+// WithMediaStore archives the downloaded media to the store
+func WithMediaStore(store MediaStoreOptions) option {
+	return func(opts *options) {
+		opts.mediaStore = store
+	}
+}
+
+// WithDedup stores the downloaded media once by content and skips downloading media stored before
+func WithDedup(enabled bool) option {
+	return func(opts *options) {
+		opts.dedup = enabled
+	}
+}
+
+// WithTransport tunes the http transport shared by the twitter client and the downloader
+func WithTransport(transport twitter.TransportOptions) option {
+	return func(opts *options) {
+		opts.transport = transport
+	}
+}
+
+// WithBandwidth limits the download speed of the media in total and per request
+func WithBandwidth(limits BandwidthLimits) option {
+	return func(opts *options) {
+		opts.bandwidth = limits
+	}
+}
+
+// StageTimeouts limits the stages of a request. Zero means no limit
+type StageTimeouts struct {
+	// getting the tweet data from X
+	Fetch    time.Duration
+	Download time.Duration
+	// uploading and sending the media to telegram
+	Upload time.Duration
+}
+
+func DefaultStageTimeouts() StageTimeouts {
+	return StageTimeouts{
+		Fetch:    time.Minute,
+		Download: 10 * time.Minute,
+		Upload:   10 * time.Minute,
+	}
+}
+
+// WithStageTimeouts sets the deadlines of the fetch, download and upload stages
+func WithStageTimeouts(timeouts StageTimeouts) option {
+	return func(opts *options) {
+		opts.timeouts = timeouts
+	}
+}
+
+func (opts *options) quota() (*Quota, error) {
+	tiers := DefaultTiers(opts.limitPerMinute, opts.limitPerHour, opts.limitPerDay, opts.limitPerWeek, opts.bytesPerDay)
+
+	for _, t := range opts.tiers {
+		replaced := false
+		for i := range tiers {
+			if tiers[i].Name == t.Name {
+				tiers[i] = t
+				replaced = true
+			}
+		}
+		if !replaced {
+			tiers = append(tiers, t)
+		}
+	}
+
+	return NewQuota(opts.quotaAlgorithm, tiers)
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"io"
+
+	"github.com/gotd/td/telegram/uploader"
+)
+
+// DownloadProgress is called as the media of a tweet are downloaded. total is -1 if unknown
+type DownloadProgress func(index int, done, total int64)
+
+type downloadProgressKey struct{}
+
+// WithDownloadProgress makes DownloadTweetData report the progress to p
+func WithDownloadProgress(ctx context.Context, p DownloadProgress) context.Context {
+	return context.WithValue(ctx, downloadProgressKey{}, p)
+}
+
+func downloadProgressFrom(ctx context.Context) DownloadProgress {
+	p, _ := ctx.Value(downloadProgressKey{}).(DownloadProgress)
+	return p
+}
+
+// progress of a single file
+type bytesProgress func(done, total int64)
+
+type bytesProgressKey struct{}
+
+func withBytesProgress(ctx context.Context, p bytesProgress) context.Context {
+	return context.WithValue(ctx, bytesProgressKey{}, p)
+}
+
+func bytesProgressFrom(ctx context.Context) bytesProgress {
+	p, _ := ctx.Value(bytesProgressKey{}).(bytesProgress)
+	return p
+}
+
+// reports the bytes read starting from done
+type progressReader struct {
+	r      io.Reader
+	done   int64
+	total  int64
+	report bytesProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+
+	if n > 0 {
+		p.done += int64(n)
+		p.report(p.done, p.total)
+	}
+
+	return n, err
+}
+
+// adapts bytesProgress to the uploader
+type uploadProgress bytesProgress
+
+func (p uploadProgress) Chunk(ctx context.Context, state uploader.ProgressState) error {
+	p(state.Uploaded, state.Total)
+	return nil
+}
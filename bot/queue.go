@@ -0,0 +1,309 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+type Job struct {
+	ID     int64
+	UserID int64
+	// priority jobs go before everything else
+	Priority bool
+	Run      func(ctx context.Context)
+	// called with the new position in the queue while the job is waiting
+	OnPosition func(pos int)
+	// called when the job is cancelled, either waiting or running
+	OnCancel func()
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// JobQueue runs jobs with a fixed number of workers. Users are served round-robin
+type JobQueue struct {
+	logger  *zap.Logger
+	workers int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	priority []*Job
+	users    map[int64][]*Job
+	// users with queued jobs in the round-robin order
+	ring      []int64
+	running   map[int64]*Job
+	positions map[int64]int
+	nextID    int64
+}
+
+type QueueState struct {
+	Workers int
+	Running int
+	Queued  int
+	// positions of the queued jobs by user
+	UserPositions map[int64][]int
+	// number of running jobs by user
+	UserRunning map[int64]int
+}
+
+func NewJobQueue(logger *zap.Logger, workers int) *JobQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	q := &JobQueue{
+		logger:    logger,
+		workers:   workers,
+		users:     make(map[int64][]*Job),
+		running:   make(map[int64]*Job),
+		positions: make(map[int64]int),
+	}
+
+	q.cond = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// Run starts the workers and blocks until the context is done and the running jobs are finished
+func (q *JobQueue) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (q *JobQueue) worker(ctx context.Context) {
+	for {
+		job := q.next(ctx)
+
+		if job == nil {
+			return
+		}
+
+		q.logger.Debug("job started", zap.Int64("job", job.ID), zap.Int64("user", job.UserID))
+
+		job.Run(job.ctx)
+
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+
+		job.cancel()
+
+		q.logger.Debug("job finished", zap.Int64("job", job.ID), zap.Int64("user", job.UserID))
+	}
+}
+
+// waits for a job. Returns nil when the context is done
+func (q *JobQueue) next(ctx context.Context) *Job {
+	q.mu.Lock()
+
+	for {
+		if ctx.Err() != nil {
+			q.mu.Unlock()
+			return nil
+		}
+
+		if job := q.popLocked(); job != nil {
+			job.ctx, job.cancel = context.WithCancel(ctx)
+			q.running[job.ID] = job
+			notify := q.positionsChangedLocked()
+			q.mu.Unlock()
+			notify()
+			return job
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// Push adds the job to the queue and returns its position
+func (q *JobQueue) Push(job *Job) int {
+	q.mu.Lock()
+
+	q.nextID++
+	job.ID = q.nextID
+
+	if job.Priority {
+		q.priority = append(q.priority, job)
+	} else {
+		if len(q.users[job.UserID]) == 0 {
+			q.ring = append(q.ring, job.UserID)
+		}
+		q.users[job.UserID] = append(q.users[job.UserID], job)
+	}
+
+	notify := q.positionsChangedLocked()
+	pos := q.positions[job.ID]
+
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	notify()
+
+	return pos
+}
+
+// Cancel removes the waiting jobs of the user and cancels the running ones.
+// Returns the number of cancelled jobs
+func (q *JobQueue) Cancel(userID int64) int {
+	q.mu.Lock()
+
+	var cancelled []*Job
+
+	priority := q.priority[:0]
+	for _, job := range q.priority {
+		if job.UserID == userID {
+			cancelled = append(cancelled, job)
+		} else {
+			priority = append(priority, job)
+		}
+	}
+	q.priority = priority
+
+	if jobs, ok := q.users[userID]; ok {
+		cancelled = append(cancelled, jobs...)
+		delete(q.users, userID)
+
+		for i, id := range q.ring {
+			if id == userID {
+				q.ring = append(q.ring[:i], q.ring[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, job := range q.running {
+		if job.UserID == userID {
+			cancelled = append(cancelled, job)
+			job.cancel()
+		}
+	}
+
+	notify := q.positionsChangedLocked()
+	q.mu.Unlock()
+
+	notify()
+
+	for _, job := range cancelled {
+		if job.OnCancel != nil {
+			job.OnCancel()
+		}
+	}
+
+	return len(cancelled)
+}
+
+func (q *JobQueue) popLocked() *Job {
+	if len(q.priority) > 0 {
+		job := q.priority[0]
+		q.priority = q.priority[1:]
+		return job
+	}
+
+	if len(q.ring) == 0 {
+		return nil
+	}
+
+	userID := q.ring[0]
+	q.ring = q.ring[1:]
+
+	jobs := q.users[userID]
+	job := jobs[0]
+
+	if len(jobs) > 1 {
+		q.users[userID] = jobs[1:]
+		// to the end of the round
+		q.ring = append(q.ring, userID)
+	} else {
+		delete(q.users, userID)
+	}
+
+	return job
+}
+
+// the order in which the queued jobs are going to be started
+func (q *JobQueue) orderLocked() []*Job {
+	order := make([]*Job, 0, len(q.priority)+len(q.ring))
+	order = append(order, q.priority...)
+
+	for round := 0; ; round++ {
+		added := false
+		for _, userID := range q.ring {
+			if jobs := q.users[userID]; round < len(jobs) {
+				order = append(order, jobs[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	return order
+}
+
+// updates the positions and returns a function calling OnPosition of the jobs that moved
+func (q *JobQueue) positionsChangedLocked() func() {
+	var changed []*Job
+	var changedPos []int
+
+	positions := make(map[int64]int)
+
+	for i, job := range q.orderLocked() {
+		pos := i + 1
+		positions[job.ID] = pos
+
+		if q.positions[job.ID] != pos && job.OnPosition != nil {
+			changed = append(changed, job)
+			changedPos = append(changedPos, pos)
+		}
+	}
+
+	q.positions = positions
+
+	return func() {
+		for i, job := range changed {
+			job.OnPosition(changedPos[i])
+		}
+	}
+}
+
+func (q *JobQueue) State() QueueState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state := QueueState{
+		Workers:       q.workers,
+		Running:       len(q.running),
+		Queued:        len(q.positions),
+		UserPositions: make(map[int64][]int),
+		UserRunning:   make(map[int64]int),
+	}
+
+	for i, job := range q.orderLocked() {
+		state.UserPositions[job.UserID] = append(state.UserPositions[job.UserID], i+1)
+	}
+
+	for _, job := range q.running {
+		state.UserRunning[job.UserID]++
+	}
+
+	return state
+}
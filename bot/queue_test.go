@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestJobQueueOrder(t *testing.T) {
+	q := NewJobQueue(zap.NewNop(), 1)
+
+	push := func(userID int64, priority bool) {
+		q.Push(&Job{UserID: userID, Priority: priority, Run: func(ctx context.Context) {}})
+	}
+
+	push(1, false)
+	push(1, false)
+	push(1, false)
+	push(2, false)
+	push(3, true)
+
+	state := q.State()
+	require.Equal(t, 5, state.Queued)
+	require.Equal(t, []int{1}, state.UserPositions[3])
+	require.Equal(t, []int{2, 4, 5}, state.UserPositions[1])
+	require.Equal(t, []int{3}, state.UserPositions[2])
+
+	var started []int64
+	for i := 0; i < 5; i++ {
+		started = append(started, q.next(context.Background()).UserID)
+	}
+
+	require.Equal(t, []int64{3, 1, 2, 1, 1}, started)
+	require.Equal(t, 5, q.State().Running)
+}
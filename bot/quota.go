@@ -0,0 +1,482 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+type QuotaAlgorithm string
+
+const (
+	// counts requests made during the last period
+	QuotaSlidingWindow QuotaAlgorithm = "sliding"
+	// refills limit tokens evenly over the period
+	QuotaTokenBucket QuotaAlgorithm = "bucket"
+)
+
+const (
+	TierDefault = "default"
+	TierTrusted = "trusted"
+	TierPremium = "premium"
+	TierAdmin   = "admin"
+)
+
+var quotaPeriods = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// prefix of windows limiting transferred bytes like bytes-day
+const bytesWindowPrefix = "bytes-"
+
+type QuotaWindow struct {
+	// minute, hour, day or week. Prefixed with bytes- for byte windows
+	Name   string
+	Period time.Duration
+	Limit  int64
+	// limits bytes downloaded from X and uploaded to telegram instead of requests
+	Bytes bool
+}
+
+func NewQuotaWindow(name string, limit int64) (QuotaWindow, error) {
+	periodName, bytes := strings.CutPrefix(name, bytesWindowPrefix)
+
+	period, ok := quotaPeriods[periodName]
+	if !ok {
+		return QuotaWindow{}, errors.Errorf("unknown window: %s", name)
+	}
+	return QuotaWindow{Name: name, Period: period, Limit: limit, Bytes: bytes}, nil
+}
+
+// ParseByteSize parses sizes like 1024, 500KB, 100MB or 2GB
+func ParseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	upper := strings.ToUpper(strings.TrimSpace(s))
+
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(upper, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "invalid size: %s", s)
+			}
+			return int64(n * float64(u.mult)), nil
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid size: %s", s)
+	}
+	return n, nil
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// String returns a description like "30 requests per day"
+func (w QuotaWindow) String() string {
+	period := strings.TrimPrefix(w.Name, bytesWindowPrefix)
+	if w.Bytes {
+		return fmt.Sprintf("%s per %s", formatBytes(w.Limit), period)
+	}
+	return fmt.Sprintf("%d requests per %s", w.Limit, period)
+}
+
+// formats the window limit or remaining value
+func (w QuotaWindow) Format(n int64) string {
+	if w.Bytes {
+		return formatBytes(n)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+type Tier struct {
+	Name      string
+	Windows   []QuotaWindow
+	Unlimited bool
+}
+
+// ParseTier parses tier spec like trusted=minute:5,day:100,bytes-day:1GB
+func ParseTier(spec string) (Tier, error) {
+	name, windows, ok := strings.Cut(spec, "=")
+
+	if !ok || name == "" {
+		return Tier{}, errors.Errorf("invalid tier spec: %s", spec)
+	}
+
+	tier := Tier{Name: name}
+
+	if windows == "unlimited" {
+		tier.Unlimited = true
+		return tier, nil
+	}
+
+	for _, w := range strings.Split(windows, ",") {
+		wname, wlimit, ok := strings.Cut(w, ":")
+		if !ok {
+			return Tier{}, errors.Errorf("invalid window spec: %s", w)
+		}
+
+		var limit int64
+		var err error
+
+		if strings.HasPrefix(wname, bytesWindowPrefix) {
+			limit, err = ParseByteSize(wlimit)
+		} else {
+			limit, err = strconv.ParseInt(wlimit, 10, 64)
+		}
+
+		if err != nil {
+			return Tier{}, errors.Wrapf(err, "invalid window limit: %s", w)
+		}
+
+		if limit <= 0 {
+			return Tier{}, errors.Errorf("window limit must be positive: %s", w)
+		}
+
+		window, err := NewQuotaWindow(wname, limit)
+		if err != nil {
+			return Tier{}, err
+		}
+
+		tier.Windows = append(tier.Windows, window)
+	}
+
+	return tier, nil
+}
+
+// DefaultTiers returns the tiers used unless overridden
+func DefaultTiers(perMinute, perHour, perDay, perWeek int, bytesPerDay int64) []Tier {
+	defaultTier := Tier{Name: TierDefault}
+
+	for _, w := range []struct {
+		name  string
+		limit int64
+	}{
+		{"minute", int64(perMinute)},
+		{"hour", int64(perHour)},
+		{"day", int64(perDay)},
+		{"week", int64(perWeek)},
+		{bytesWindowPrefix + "day", bytesPerDay},
+	} {
+		if w.limit <= 0 {
+			continue
+		}
+		window, _ := NewQuotaWindow(w.name, w.limit)
+		defaultTier.Windows = append(defaultTier.Windows, window)
+	}
+
+	return []Tier{
+		defaultTier,
+		{Name: TierTrusted, Windows: []QuotaWindow{
+			{Name: "minute", Period: time.Minute, Limit: 10},
+			{Name: "day", Period: 24 * time.Hour, Limit: 200},
+		}},
+		{Name: TierPremium, Windows: []QuotaWindow{
+			{Name: "minute", Period: time.Minute, Limit: 20},
+			{Name: "day", Period: 24 * time.Hour, Limit: 1000},
+		}},
+		{Name: TierAdmin, Unlimited: true},
+	}
+}
+
+// QuotaState is the per user state stored in UserData
+type QuotaState struct {
+	// request times for sliding windows
+	Requests []time.Time `json:",omitempty"`
+	// transferred bytes for sliding byte windows
+	Transfers []QuotaTransfer `json:",omitempty"`
+	// token buckets by window name
+	Buckets map[string]QuotaBucket `json:",omitempty"`
+}
+
+type QuotaTransfer struct {
+	Time  time.Time
+	Bytes int64
+}
+
+type QuotaBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+func (qs QuotaState) copy() QuotaState {
+	c := QuotaState{}
+	if qs.Requests != nil {
+		c.Requests = make([]time.Time, len(qs.Requests))
+		copy(c.Requests, qs.Requests)
+	}
+	if qs.Transfers != nil {
+		c.Transfers = make([]QuotaTransfer, len(qs.Transfers))
+		copy(c.Transfers, qs.Transfers)
+	}
+	if qs.Buckets != nil {
+		c.Buckets = make(map[string]QuotaBucket, len(qs.Buckets))
+		for k, v := range qs.Buckets {
+			c.Buckets[k] = v
+		}
+	}
+	return c
+}
+
+type WindowStatus struct {
+	Window    QuotaWindow
+	Remaining int64
+	// when the next request becomes available if nothing is remaining
+	NextAt time.Time
+	// when the whole limit becomes available again
+	ResetAt time.Time
+}
+
+func (ws WindowStatus) Exhausted() bool {
+	return ws.Remaining <= 0
+}
+
+type Quota struct {
+	algorithm QuotaAlgorithm
+	tiers     map[string]Tier
+	// sliding window requests older than this are dropped
+	maxPeriod time.Duration
+}
+
+func NewQuota(algorithm QuotaAlgorithm, tiers []Tier) (*Quota, error) {
+	if algorithm != QuotaSlidingWindow && algorithm != QuotaTokenBucket {
+		return nil, errors.Errorf("unknown quota algorithm: %s", algorithm)
+	}
+
+	q := &Quota{
+		algorithm: algorithm,
+		tiers:     make(map[string]Tier),
+	}
+
+	for _, t := range tiers {
+		q.tiers[t.Name] = t
+		for _, w := range t.Windows {
+			if w.Period > q.maxPeriod {
+				q.maxPeriod = w.Period
+			}
+		}
+	}
+
+	if _, ok := q.tiers[TierDefault]; !ok {
+		return nil, errors.New("default tier is missing")
+	}
+
+	return q, nil
+}
+
+func (q *Quota) HasTier(name string) bool {
+	_, ok := q.tiers[name]
+	return ok
+}
+
+func (q *Quota) TierNames() []string {
+	names := make([]string, 0, len(q.tiers))
+	for name := range q.tiers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Tier returns the named tier falling back to the default one
+func (q *Quota) Tier(name string) Tier {
+	if t, ok := q.tiers[name]; ok {
+		return t
+	}
+	return q.tiers[TierDefault]
+}
+
+// Status returns the state of each window of the tier
+func (q *Quota) Status(tier Tier, state QuotaState, now time.Time) []WindowStatus {
+	if tier.Unlimited {
+		return nil
+	}
+
+	res := make([]WindowStatus, len(tier.Windows))
+
+	for i, w := range tier.Windows {
+		switch {
+		case q.algorithm == QuotaTokenBucket:
+			res[i] = bucketStatus(w, state.Buckets[w.Name], now)
+		case w.Bytes:
+			res[i] = slidingStatus(w, state.Transfers, now)
+		default:
+			res[i] = slidingStatus(w, requestTransfers(state.Requests), now)
+		}
+	}
+
+	return res
+}
+
+// Allow returns the exhausted window if the request is not allowed
+func (q *Quota) Allow(tier Tier, state QuotaState, now time.Time) (WindowStatus, bool) {
+	for _, ws := range q.Status(tier, state, now) {
+		if ws.Exhausted() {
+			return ws, false
+		}
+	}
+	return WindowStatus{}, true
+}
+
+// Consume records a request
+func (q *Quota) Consume(tier Tier, state *QuotaState, now time.Time) {
+	if tier.Unlimited {
+		return
+	}
+
+	if q.algorithm == QuotaTokenBucket {
+		q.consumeBuckets(tier, state, now, false, 1)
+		return
+	}
+
+	kept := state.Requests[:0]
+	for _, t := range state.Requests {
+		if now.Sub(t) < q.maxPeriod {
+			kept = append(kept, t)
+		}
+	}
+	state.Requests = append(kept, now)
+}
+
+// ConsumeBytes records transferred bytes
+func (q *Quota) ConsumeBytes(tier Tier, state *QuotaState, now time.Time, bytes int64) {
+	if tier.Unlimited || bytes <= 0 {
+		return
+	}
+
+	if q.algorithm == QuotaTokenBucket {
+		q.consumeBuckets(tier, state, now, true, bytes)
+		return
+	}
+
+	kept := state.Transfers[:0]
+	for _, t := range state.Transfers {
+		if now.Sub(t.Time) < q.maxPeriod {
+			kept = append(kept, t)
+		}
+	}
+	state.Transfers = append(kept, QuotaTransfer{Time: now, Bytes: bytes})
+}
+
+func (q *Quota) consumeBuckets(tier Tier, state *QuotaState, now time.Time, bytes bool, n int64) {
+	if state.Buckets == nil {
+		state.Buckets = make(map[string]QuotaBucket)
+	}
+
+	for _, w := range tier.Windows {
+		if w.Bytes != bytes {
+			continue
+		}
+		b := refill(w, state.Buckets[w.Name], now)
+		// a large transfer leaves the bucket in debt
+		b.Tokens -= float64(n)
+		if !bytes {
+			b.Tokens = math.Max(0, b.Tokens)
+		}
+		state.Buckets[w.Name] = b
+	}
+}
+
+func requestTransfers(requests []time.Time) []QuotaTransfer {
+	res := make([]QuotaTransfer, len(requests))
+	for i, t := range requests {
+		res[i] = QuotaTransfer{Time: t, Bytes: 1}
+	}
+	return res
+}
+
+// transfers are appended in order
+func slidingStatus(w QuotaWindow, transfers []QuotaTransfer, now time.Time) WindowStatus {
+	var inWindow []QuotaTransfer
+	var used int64
+
+	for _, t := range transfers {
+		if now.Sub(t.Time) < w.Period {
+			inWindow = append(inWindow, t)
+			used += t.Bytes
+		}
+	}
+
+	ws := WindowStatus{Window: w, Remaining: w.Limit - used}
+
+	if len(inWindow) == 0 {
+		return ws
+	}
+
+	ws.ResetAt = inWindow[len(inWindow)-1].Time.Add(w.Period)
+
+	if ws.Remaining > 0 {
+		return ws
+	}
+
+	// the oldest transfers expire until there is room for a new one
+	for _, t := range inWindow {
+		used -= t.Bytes
+		if used < w.Limit {
+			ws.NextAt = t.Time.Add(w.Period)
+			break
+		}
+	}
+
+	return ws
+}
+
+// new buckets start full
+func refill(w QuotaWindow, b QuotaBucket, now time.Time) QuotaBucket {
+	limit := float64(w.Limit)
+
+	if b.Updated.IsZero() {
+		return QuotaBucket{Tokens: limit, Updated: now}
+	}
+
+	rate := limit / w.Period.Seconds()
+	tokens := b.Tokens + now.Sub(b.Updated).Seconds()*rate
+
+	return QuotaBucket{Tokens: math.Min(limit, tokens), Updated: now}
+}
+
+func bucketStatus(w QuotaWindow, b QuotaBucket, now time.Time) WindowStatus {
+	b = refill(w, b, now)
+	rate := float64(w.Limit) / w.Period.Seconds()
+
+	ws := WindowStatus{Window: w, Remaining: int64(math.Floor(b.Tokens))}
+
+	if b.Tokens < float64(w.Limit) {
+		ws.ResetAt = now.Add(time.Duration((float64(w.Limit) - b.Tokens) / rate * float64(time.Second)))
+	}
+
+	if b.Tokens < 1 {
+		ws.NextAt = now.Add(time.Duration((1 - b.Tokens) / rate * float64(time.Second)))
+	}
+
+	return ws
+}
+
+// formats the duration until t as 1h2m
+func formatUntil(t time.Time, now time.Time) string {
+	d := t.Sub(now)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(math.Ceil(d.Seconds())))
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
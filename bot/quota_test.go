@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaSlidingWindow(t *testing.T) {
+	tier, err := ParseTier("test=minute:2,day:3")
+	require.NoError(t, err)
+
+	q, err := NewQuota(QuotaSlidingWindow, []Tier{{Name: TierDefault}, tier})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC)
+	state := QuotaState{}
+
+	q.Consume(tier, &state, now)
+	q.Consume(tier, &state, now.Add(time.Second))
+
+	ws, ok := q.Allow(tier, state, now.Add(2*time.Second))
+	require.False(t, ok)
+	require.Equal(t, "minute", ws.Window.Name)
+	require.Equal(t, now.Add(time.Minute), ws.NextAt)
+
+	_, ok = q.Allow(tier, state, now.Add(time.Minute))
+	require.True(t, ok)
+
+	q.Consume(tier, &state, now.Add(time.Minute))
+
+	ws, ok = q.Allow(tier, state, now.Add(time.Hour))
+	require.False(t, ok)
+	require.Equal(t, "day", ws.Window.Name)
+
+	// same day of the next month
+	_, ok = q.Allow(tier, state, now.AddDate(0, 1, 0))
+	require.True(t, ok)
+}
+
+func TestQuotaTokenBucket(t *testing.T) {
+	tier, err := ParseTier("test=hour:2")
+	require.NoError(t, err)
+
+	q, err := NewQuota(QuotaTokenBucket, []Tier{{Name: TierDefault}, tier})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC)
+	state := QuotaState{}
+
+	q.Consume(tier, &state, now)
+	q.Consume(tier, &state, now)
+
+	ws, ok := q.Allow(tier, state, now)
+	require.False(t, ok)
+	require.Equal(t, now.Add(30*time.Minute), ws.NextAt)
+
+	_, ok = q.Allow(tier, state, now.Add(30*time.Minute))
+	require.True(t, ok)
+}
+
+func TestParseTier(t *testing.T) {
+	tier, err := ParseTier("premium=unlimited")
+	require.NoError(t, err)
+	require.True(t, tier.Unlimited)
+
+	_, err = ParseTier("premium=month:5")
+	require.Error(t, err)
+
+	_, err = ParseTier("premium")
+	require.Error(t, err)
+}
+
+func TestQuotaBytes(t *testing.T) {
+	tier, err := ParseTier("test=day:100,bytes-day:1MB")
+	require.NoError(t, err)
+
+	q, err := NewQuota(QuotaSlidingWindow, []Tier{{Name: TierDefault}, tier})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC)
+	state := QuotaState{}
+
+	q.ConsumeBytes(tier, &state, now, 600<<10)
+	_, ok := q.Allow(tier, state, now)
+	require.True(t, ok)
+
+	q.ConsumeBytes(tier, &state, now.Add(time.Hour), 600<<10)
+	ws, ok := q.Allow(tier, state, now.Add(time.Hour))
+	require.False(t, ok)
+	require.True(t, ws.Window.Bytes)
+	require.Equal(t, now.Add(24*time.Hour), ws.NextAt)
+}
@@ -0,0 +1,340 @@
+package bot
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+)
+
+// RetentionOptions limits the media kept in the download folder. Zero means no limit
+type RetentionOptions struct {
+	// remove the files of a request once they are sent
+	DeleteAfterUpload bool
+	// the least recently used files are removed first
+	MaxTotalSize int64
+	MaxAge       time.Duration
+	// zero disables the periodic sweeping
+	SweepInterval time.Duration
+}
+
+func DefaultRetentionOptions() RetentionOptions {
+	return RetentionOptions{
+		SweepInterval: 10 * time.Minute,
+	}
+}
+
+// part and temporary files not modified for this long are considered abandoned
+const partMaxAge = time.Hour
+
+// suffix of the temporary files of atomic writes and media processing
+const tmpSuffix = ".tmp"
+
+// extensions of the files the sweeper may remove. Other files like users data are never touched
+var mediaExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".mp4": true, ".m4v": true, ".m4a": true, ".mov": true,
+}
+
+type SweepResult struct {
+	Removed       int
+	Freed         int64
+	Remaining     int
+	RemainingSize int64
+}
+
+// Sweeper removes media files from the folder according to the retention options
+type Sweeper struct {
+	dir     string
+	opts    RetentionOptions
+	logger  *zap.Logger
+	nowFunc func() time.Time
+
+	mu sync.Mutex
+
+	heldLock sync.Mutex
+	held     map[string]int
+
+	// the part and temporary files older than this are left from the previous run
+	started time.Time
+}
+
+func NewSweeper(dir string, opts RetentionOptions, logger *zap.Logger) *Sweeper {
+	return &Sweeper{
+		dir:     dir,
+		opts:    opts,
+		logger:  logger,
+		nowFunc: time.Now,
+		held:    make(map[string]int),
+		started: time.Now(),
+	}
+}
+
+// Hold protects the files from being swept until the returned function is called.
+// The release marks the files as used by setting their modification time
+func (s *Sweeper) Hold(paths ...string) func() {
+	s.heldLock.Lock()
+	defer s.heldLock.Unlock()
+
+	for _, p := range paths {
+		s.held[filepath.Clean(p)]++
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			s.heldLock.Lock()
+			defer s.heldLock.Unlock()
+
+			for _, p := range paths {
+				p = filepath.Clean(p)
+				if s.held[p]--; s.held[p] <= 0 {
+					delete(s.held, p)
+				}
+			}
+
+			s.touch(paths)
+		})
+	}
+}
+
+// the modification time is the last use of the file
+func (s *Sweeper) touch(paths []string) {
+	now := s.nowFunc()
+
+	for _, p := range paths {
+		if err := os.Chtimes(p, now, now); err != nil && !os.IsNotExist(err) {
+			s.logger.Error("failed to touch file", zap.String("path", p), zap.Error(err))
+		}
+	}
+}
+
+func (s *Sweeper) isHeld(path string) bool {
+	return s.holds(path) > 0
+}
+
+// returns the number of the holds of the file
+func (s *Sweeper) holds(path string) int {
+	s.heldLock.Lock()
+	defer s.heldLock.Unlock()
+	return s.held[filepath.Clean(path)]
+}
+
+func (s *Sweeper) isHeldAny(paths []string) bool {
+	for _, p := range paths {
+		if s.isHeld(p) {
+			return true
+		}
+	}
+	return false
+}
+
+type sweepFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	info    fs.FileInfo
+	// other hard links to the same file like the objects of the content store
+	links []string
+}
+
+func (f sweepFile) paths() []string {
+	return append([]string{f.path}, f.links...)
+}
+
+// merges hard links to the same file so it is counted and removed once
+func mergeLinks(files []sweepFile) []sweepFile {
+	type linkKey struct {
+		size    int64
+		modTime int64
+	}
+
+	// links share the size and modification time
+	candidates := make(map[linkKey][]int)
+	merged := files[:0]
+
+	for _, f := range files {
+		key := linkKey{f.size, f.modTime.UnixNano()}
+		found := false
+
+		for _, i := range candidates[key] {
+			if os.SameFile(merged[i].info, f.info) {
+				merged[i].links = append(merged[i].links, f.path)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			candidates[key] = append(candidates[key], len(merged))
+			merged = append(merged, f)
+		}
+	}
+
+	return merged
+}
+
+// Sweep removes the files unused for longer than the max age and the least recently used files
+// over the total size. allParts removes all the part and temporary files left from the previous run,
+// otherwise only abandoned ones
+func (s *Sweeper) Sweep(allParts bool) (SweepResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.nowFunc()
+
+	var result SweepResult
+	var files []sweepFile
+
+	remove := func(f sweepFile, reason string) {
+		for _, p := range f.paths() {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				s.logger.Error("failed to remove file", zap.String("path", p), zap.Error(err))
+				return
+			}
+			s.logger.Debug("Removed file", zap.String("path", p), zap.String("reason", reason))
+			result.Removed++
+		}
+		result.Freed += f.size
+	}
+
+	err := filepath.WalkDir(s.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if e.IsDir() {
+			return nil
+		}
+
+		name := e.Name()
+		isPart := strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, tmpSuffix)
+
+		if !isPart && !mediaExtensions[strings.ToLower(filepath.Ext(name))] {
+			return nil
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			// removed meanwhile
+			return nil
+		}
+
+		f := sweepFile{path: path, size: info.Size(), modTime: info.ModTime(), info: info}
+
+		switch {
+		case s.isHeld(path):
+			files = append(files, f)
+		case isPart:
+			if allParts && f.modTime.Before(s.started) || now.Sub(f.modTime) > partMaxAge {
+				remove(f, "part")
+			}
+		case s.opts.MaxAge > 0 && now.Sub(f.modTime) > s.opts.MaxAge:
+			remove(f, "age")
+		default:
+			files = append(files, f)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return result, errors.Wrap(err, "walk download folder")
+	}
+
+	files = mergeLinks(files)
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	if s.opts.MaxTotalSize > 0 && total > s.opts.MaxTotalSize {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+		kept := files[:0]
+
+		for _, f := range files {
+			if total > s.opts.MaxTotalSize && !s.isHeldAny(f.paths()) {
+				remove(f, "size")
+				total -= f.size
+				continue
+			}
+			kept = append(kept, f)
+		}
+
+		files = kept
+	}
+
+	result.Remaining = len(files)
+	result.RemainingSize = total
+
+	return result, nil
+}
+
+// SweepStart removes the part and temporary files left from the previous run. It's called
+// before any job starts
+func (s *Sweeper) SweepStart() {
+	s.sweep(true)
+}
+
+// Run sweeps periodically until the context is done
+func (s *Sweeper) Run(ctx context.Context) {
+	if s.opts.SweepInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(false)
+		}
+	}
+}
+
+func (s *Sweeper) sweep(allParts bool) {
+	result, err := s.Sweep(allParts)
+
+	if err != nil {
+		s.logger.Error("failed to sweep download folder", zap.Error(err))
+		return
+	}
+
+	if result.Removed > 0 {
+		s.logger.Info("Swept download folder",
+			zap.Int("removed", result.Removed),
+			zap.Int64("freed", result.Freed),
+			zap.Int("remaining", result.Remaining),
+			zap.Int64("remainingSize", result.RemainingSize),
+		)
+	}
+}
+
+// removes the files of a sent request if configured
+func (s *Sweeper) Done(paths ...string) {
+	if !s.opts.DeleteAfterUpload {
+		return
+	}
+
+	for _, p := range paths {
+		if p == "" || s.isHeld(p) {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			s.logger.Error("failed to remove file", zap.String("path", p), zap.Error(err))
+		}
+	}
+}
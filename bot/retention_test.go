@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSweeper(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	write := func(name string, size int, age time.Duration) string {
+		p := path.Join(dir, name)
+		require.NoError(t, os.MkdirAll(path.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, make([]byte, size), 0644))
+		require.NoError(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+		return p
+	}
+
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	expired := write("expired.jpg", 10, 48*time.Hour)
+	oldest := write("sub/oldest.mp4", 100, 3*time.Hour)
+	held := write("held.mp4", 100, 2*time.Hour)
+	newest := write("newest.jpg", 100, time.Hour)
+	part := write("active.mp4.part", 10, time.Minute)
+	tmp := write("users.json.1.tmp", 10, time.Minute)
+	abandoned := write("a.mp4.1.tmp", 10, 2*time.Hour)
+	users := write("users.json", 1000, 100*time.Hour)
+
+	s := NewSweeper(dir, RetentionOptions{MaxAge: 24 * time.Hour, MaxTotalSize: 200}, zap.NewNop())
+	release := s.Hold(held)
+
+	result, err := s.Sweep(false)
+	require.NoError(t, err)
+
+	require.False(t, exists(expired))
+	require.False(t, exists(oldest))
+	require.True(t, exists(held))
+	require.True(t, exists(newest))
+	require.True(t, exists(part))
+	require.True(t, exists(tmp))
+	require.False(t, exists(abandoned))
+	require.True(t, exists(users))
+	require.Equal(t, 3, result.Removed)
+	require.Equal(t, 2, result.Remaining)
+
+	// the release makes the held file the most recently used
+	release()
+
+	s.opts.MaxTotalSize = 150
+
+	_, err = s.Sweep(true)
+	require.NoError(t, err)
+
+	require.True(t, exists(held))
+	require.False(t, exists(newest))
+	require.False(t, exists(part))
+	require.False(t, exists(tmp))
+
+	// made after the start
+	fresh := path.Join(dir, "users.json.2.tmp")
+	require.NoError(t, os.WriteFile(fresh, nil, 0644))
+
+	_, err = s.Sweep(true)
+	require.NoError(t, err)
+	require.True(t, exists(fresh))
+}
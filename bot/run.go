@@ -2,6 +2,8 @@ package bot
 
 import (
 	"context"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-faster/errors"
@@ -11,6 +13,7 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"github.com/nktknshn/go-twitter-download-bot/cli/logging"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
@@ -26,12 +29,56 @@ func Run(ctx context.Context,
 		useRateLimiter: true,
 		debugTelegram:  false,
 		sessionFile:    "twitter-downloader-session.json",
+
+		twitterRateLimits: twitter.DefaultRateLimits(),
+		breakerSettings:   twitter.DefaultBreakerSettings(),
+
+		useCache:     true,
+		cacheOptions: twitter.DefaultCacheOptions(),
+
+		userStorageKind: UserStorageKindBolt,
+
+		quotaAlgorithm: QuotaSlidingWindow,
+
+		workers:   4,
+		transport: twitter.DefaultTransportOptions(),
+		timeouts:  DefaultStageTimeouts(),
+
+		downloadsPerJob: 4,
+		downloadsGlobal: 16,
+
+		variantLimits: DefaultVariantLimits(),
+		collage:       DefaultCollageOptions(),
+		card:          DefaultCardOptions(),
+		retention:     DefaultRetentionOptions(),
 	}
 
 	for _, opt := range opts {
 		opt(options)
 	}
 
+	quota, err := options.quota()
+
+	if err != nil {
+		return errors.Wrap(err, "quota")
+	}
+
+	if options.card.Width <= 0 || options.card.MaxLines < 0 {
+		return errors.Errorf("invalid card options: width %d, max lines %d", options.card.Width, options.card.MaxLines)
+	}
+
+	if options.forwardCard != "" {
+		if _, err := ParseCardTheme(options.forwardCard); err != nil {
+			return errors.Wrap(err, "forward card")
+		}
+	}
+
+	store, err := newMediaStore(ctx, options, downloadFolder)
+
+	if err != nil {
+		return errors.Wrap(err, "media store")
+	}
+
 	handler := &Handler{
 		Logger:            options.logger,
 		dispatcher:        tg.NewUpdateDispatcher(),
@@ -43,10 +90,39 @@ func Run(ctx context.Context,
 		IncludeText:       options.includeText,
 		IncludeURL:        options.includeURL,
 		IncludeBotName:    options.includeBotName,
-		limitPerDay:       options.limitPerDay,
+		quota:             quota,
 		limitPending:      options.limitPending,
+		twitterRateLimits: options.twitterRateLimits,
+		breakerSettings:   options.breakerSettings,
+		useCache:          options.useCache,
+		cacheOptions:      options.cacheOptions,
+		fileRefsFile:      options.fileRefsFile,
+		userStorageKind:   options.userStorageKind,
+		userStorageFile:   options.userStorageFile,
+		workers:           options.workers,
+		timeouts:          options.timeouts,
+		downloadsPerJob:   options.downloadsPerJob,
+		downloadsGlobal:   options.downloadsGlobal,
+		streaming:         options.streaming,
+		variantLimits:     options.variantLimits,
+		collage:           options.collage,
+		forwardCollage:    options.forwardCollage,
+		card:              options.card,
+		forwardCard:       options.forwardCard,
+		retention:         options.retention,
+		filenameTemplate:  options.filenameTemplate,
+		store:             store,
+		dedup:             options.dedup,
+		transport:         options.transport,
+		bandwidth:         options.bandwidth,
 	}
 
+	defer func() {
+		if err := handler.Close(); err != nil {
+			handler.Logger.Error("failed to close handler", zap.Error(err))
+		}
+	}()
+
 	tgLogger := zap.NewNop()
 
 	if handler.debugTelegram {
@@ -93,6 +169,11 @@ func Run(ctx context.Context,
 					return errors.Wrap(err, "failed to get self username")
 				}
 
+				handler.sweeper.SweepStart()
+
+				go handler.jobs.Run(ctx)
+				go handler.sweeper.Run(ctx)
+
 				return telegram.RunUntilCanceled(ctx, client)
 			},
 		)
@@ -104,3 +185,33 @@ func Run(ctx context.Context,
 
 	return runBot(ctx)
 }
+
+func newMediaStore(ctx context.Context, options *options, downloadFolder string) (MediaStore, error) {
+	if options.mediaStore.Kind == MediaStoreKindLocal {
+		rel, err := filepath.Rel(downloadFolder, options.mediaStore.Dir)
+		// the sweeper would remove the archived media
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return nil, errors.New("media store folder must be outside of the download folder")
+		}
+	}
+
+	store, err := NewMediaStore(options.mediaStore)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s3, ok := store.(*S3MediaStore); ok {
+		if err := s3.EnsureBucket(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if store != nil && options.streaming {
+		// archiving needs the files
+		options.logger.Warn("Streaming is disabled because media is archived")
+		options.streaming = false
+	}
+
+	return store, nil
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+type MediaStoreKind string
+
+const (
+	MediaStoreKindNone  MediaStoreKind = "none"
+	MediaStoreKindLocal MediaStoreKind = "local"
+	MediaStoreKindS3    MediaStoreKind = "s3"
+)
+
+var ErrMediaNotFound = errors.New("media not found")
+
+// MediaMeta describes the tweet the media comes from
+type MediaMeta struct {
+	TweetID  string    `json:"tweet_id"`
+	TweetURL string    `json:"tweet_url"`
+	Author   string    `json:"author"`
+	Date     time.Time `json:"date"`
+	// only kept in sidecars
+	Text        string `json:"text,omitempty"`
+	MediaKey    string `json:"media_key"`
+	Kind        string `json:"kind"`
+	SourceURL   string `json:"source_url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// MediaStore archives media by keys like author/id_1.jpg
+type MediaStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, meta MediaMeta) error
+	// Get returns ErrMediaNotFound if there is no such key
+	Get(ctx context.Context, key string) (io.ReadCloser, MediaMeta, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type MediaStoreOptions struct {
+	Kind MediaStoreKind
+	// folder of the local store
+	Dir string
+	S3  S3Options
+}
+
+// NewMediaStore returns nil if the kind is none
+func NewMediaStore(opts MediaStoreOptions) (MediaStore, error) {
+	switch opts.Kind {
+	case "", MediaStoreKindNone:
+		return nil, nil
+	case MediaStoreKindLocal:
+		return NewLocalMediaStore(opts.Dir)
+	case MediaStoreKindS3:
+		return NewS3MediaStore(opts.S3)
+	}
+	return nil, errors.Errorf("unknown media store: %s", opts.Kind)
+}
+
+func mediaContentType(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	case ".mp4":
+		return "video/mp4"
+	case ".m4a":
+		return "audio/mp4"
+	}
+	return "application/octet-stream"
+}
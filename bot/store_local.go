@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+// sidecars of the local store are named key + sidecarSuffix
+const sidecarSuffix = ".json"
+
+// LocalMediaStore keeps media in a folder with the metadata in json sidecars
+type LocalMediaStore struct {
+	Dir string
+}
+
+func NewLocalMediaStore(dir string) (*LocalMediaStore, error) {
+	if dir == "" {
+		return nil, errors.New("local media store folder is required")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create media store folder")
+	}
+
+	return &LocalMediaStore{Dir: dir}, nil
+}
+
+func (s *LocalMediaStore) path(key string) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(key))
+
+	if !strings.HasPrefix(p, filepath.Clean(s.Dir)+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid key %q", key)
+	}
+
+	return p, nil
+}
+
+func (s *LocalMediaStore) Put(ctx context.Context, key string, r io.Reader, size int64, meta MediaMeta) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return errors.Wrap(err, "create folder")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	written, err := io.Copy(tmp, r)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && size >= 0 && written != size {
+		err = errors.Errorf("written %d bytes of %d", written, size)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write media")
+	}
+
+	meta.Size = written
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "marshal meta")
+	}
+
+	// the sidecar goes first so there is no media without metadata
+	if err := writeFileAtomic(p+sidecarSuffix, data, 0644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write sidecar")
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return errors.Wrap(err, "rename media")
+	}
+
+	return nil
+}
+
+func (s *LocalMediaStore) Get(ctx context.Context, key string) (io.ReadCloser, MediaMeta, error) {
+	var meta MediaMeta
+
+	p, err := s.path(key)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	data, err := os.ReadFile(p + sidecarSuffix)
+
+	if os.IsNotExist(err) {
+		return nil, meta, ErrMediaNotFound
+	}
+
+	if err != nil {
+		return nil, meta, errors.Wrap(err, "read sidecar")
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, meta, errors.Wrap(err, "unmarshal sidecar")
+	}
+
+	f, err := os.Open(p)
+
+	if os.IsNotExist(err) {
+		return nil, meta, ErrMediaNotFound
+	}
+
+	if err != nil {
+		return nil, meta, errors.Wrap(err, "open media")
+	}
+
+	return f, meta, nil
+}
+
+func (s *LocalMediaStore) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(p)
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "stat media")
+	}
+
+	return true, nil
+}
+
+func (s *LocalMediaStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []string{p, p + sidecarSuffix} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove media")
+		}
+	}
+
+	return nil
+}
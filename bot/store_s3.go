@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Options struct {
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	// prepended to the keys
+	Prefix string
+	UseSSL bool
+}
+
+// S3MediaStore keeps media in an S3 compatible storage. The metadata is kept in the object
+// metadata and the main fields also in the object tags. Tweet text is not kept
+type S3MediaStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3MediaStore(opts S3Options) (*S3MediaStore, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "create s3 client")
+	}
+
+	return &S3MediaStore{client: client, bucket: opts.Bucket, prefix: opts.Prefix}, nil
+}
+
+// EnsureBucket creates the bucket if it doesn't exist
+func (s *S3MediaStore) EnsureBucket(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+
+	if err != nil {
+		return errors.Wrap(err, "check bucket")
+	}
+
+	if exists {
+		return nil
+	}
+
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{}); err != nil {
+		return errors.Wrap(err, "make bucket")
+	}
+
+	return nil
+}
+
+func (s *S3MediaStore) key(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+const (
+	s3MetaTweetID   = "Tweet-Id"
+	s3MetaTweetURL  = "Tweet-Url"
+	s3MetaAuthor    = "Author"
+	s3MetaDate      = "Date"
+	s3MetaMediaKey  = "Media-Key"
+	s3MetaKind      = "Kind"
+	s3MetaSourceURL = "Source-Url"
+)
+
+func (s *S3MediaStore) Put(ctx context.Context, key string, r io.Reader, size int64, meta MediaMeta) error {
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = mediaContentType(path.Ext(key))
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(key), r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+		UserMetadata: map[string]string{
+			s3MetaTweetID:   meta.TweetID,
+			s3MetaTweetURL:  meta.TweetURL,
+			s3MetaAuthor:    meta.Author,
+			s3MetaDate:      meta.Date.UTC().Format(time.RFC3339),
+			s3MetaMediaKey:  meta.MediaKey,
+			s3MetaKind:      meta.Kind,
+			s3MetaSourceURL: meta.SourceURL,
+		},
+		// tag values allow a limited set of characters so urls are not tagged
+		UserTags: map[string]string{
+			"tweet_id":  meta.TweetID,
+			"author":    meta.Author,
+			"media_key": meta.MediaKey,
+			"kind":      meta.Kind,
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "put object")
+	}
+
+	return nil
+}
+
+func isS3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (s *S3MediaStore) Get(ctx context.Context, key string) (io.ReadCloser, MediaMeta, error) {
+	var meta MediaMeta
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(key), minio.GetObjectOptions{})
+
+	if err != nil {
+		return nil, meta, errors.Wrap(err, "get object")
+	}
+
+	info, err := obj.Stat()
+
+	if err != nil {
+		obj.Close()
+		if isS3NotFound(err) {
+			return nil, meta, ErrMediaNotFound
+		}
+		return nil, meta, errors.Wrap(err, "stat object")
+	}
+
+	um := make(map[string]string, len(info.UserMetadata))
+	for k, v := range info.UserMetadata {
+		um[strings.ToLower(k)] = v
+	}
+
+	get := func(k string) string { return um[strings.ToLower(k)] }
+
+	meta = MediaMeta{
+		TweetID:     get(s3MetaTweetID),
+		TweetURL:    get(s3MetaTweetURL),
+		Author:      get(s3MetaAuthor),
+		MediaKey:    get(s3MetaMediaKey),
+		Kind:        get(s3MetaKind),
+		SourceURL:   get(s3MetaSourceURL),
+		ContentType: info.ContentType,
+		Size:        info.Size,
+	}
+
+	meta.Date, _ = time.Parse(time.RFC3339, get(s3MetaDate))
+
+	return obj, meta, nil
+}
+
+func (s *S3MediaStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.StatObject(ctx, s.bucket, s.key(key), minio.StatObjectOptions{})
+
+	if err != nil {
+		if isS3NotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "stat object")
+	}
+
+	return true, nil
+}
+
+func (s *S3MediaStore) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, s.key(key), minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrap(err, "remove object")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testMediaStore(t *testing.T, store MediaStore) {
+	ctx := context.Background()
+	key := "user/1742878545549087076_1.jpg"
+	data := []byte("media")
+
+	meta := MediaMeta{
+		TweetID:   "1742878545549087076",
+		TweetURL:  "https://x.com/user/status/1742878545549087076",
+		Author:    "user",
+		Date:      time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC),
+		MediaKey:  "3_1",
+		Kind:      "photo",
+		SourceURL: "https://pbs.twimg.com/media/1.jpg",
+	}
+
+	exists, err := store.Exists(ctx, key)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	_, _, err = store.Get(ctx, key)
+	require.ErrorIs(t, err, ErrMediaNotFound)
+
+	require.NoError(t, store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), meta))
+
+	exists, err = store.Exists(ctx, key)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	r, got, err := store.Get(ctx, key)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	require.Equal(t, data, body)
+
+	require.Equal(t, meta.TweetID, got.TweetID)
+	require.Equal(t, meta.TweetURL, got.TweetURL)
+	require.Equal(t, meta.Author, got.Author)
+	require.True(t, meta.Date.Equal(got.Date))
+	require.Equal(t, meta.MediaKey, got.MediaKey)
+	require.Equal(t, meta.SourceURL, got.SourceURL)
+	require.Equal(t, int64(len(data)), got.Size)
+
+	require.NoError(t, store.Delete(ctx, key))
+
+	exists, err = store.Exists(ctx, key)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestLocalMediaStore(t *testing.T) {
+	store, err := NewLocalMediaStore(t.TempDir())
+	require.NoError(t, err)
+
+	testMediaStore(t, store)
+
+	err = store.Put(context.Background(), "../escape.jpg", bytes.NewReader(nil), 0, MediaMeta{})
+	require.Error(t, err)
+}
+
+// runs against a local minio like
+// docker run -p 9000:9000 minio/minio server /data
+// TEST_S3_ENDPOINT=localhost:9000 TEST_S3_ACCESS_KEY=minioadmin TEST_S3_SECRET_KEY=minioadmin go test ./bot
+func TestS3MediaStore(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+
+	bucket := os.Getenv("TEST_S3_BUCKET")
+	if bucket == "" {
+		bucket = "twitter-bot-test"
+	}
+
+	store, err := NewS3MediaStore(S3Options{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+		Prefix:    "test",
+		UseSSL:    os.Getenv("TEST_S3_SSL") == "1",
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.EnsureBucket(context.Background()))
+
+	testMediaStore(t, store)
+}
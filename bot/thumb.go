@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+
+	// decoders of the posters
+	_ "golang.org/x/image/webp"
+	_ "image/png"
+
+	"github.com/go-faster/errors"
+	"golang.org/x/image/draw"
+)
+
+// telegram thumbnail limits
+const (
+	thumbMaxSide = 320
+	thumbMaxSize = 200 << 10
+)
+
+// posters larger than this are not downloaded
+const posterMaxSize = 10 << 20
+
+// makeThumb scales the image down to the thumbnail limits and encodes it as jpeg
+func makeThumb(r io.Reader) ([]byte, error) {
+	img, _, err := image.Decode(r)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("empty image")
+	}
+
+	if w > thumbMaxSide || h > thumbMaxSide {
+		if w >= h {
+			w, h = thumbMaxSide, max(1, h*thumbMaxSide/w)
+		} else {
+			w, h = max(1, w*thumbMaxSide/h), thumbMaxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+
+	for quality := 85; quality > 0; quality -= 20 {
+		buf.Reset()
+
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, errors.Wrap(err, "encode jpeg")
+		}
+
+		if buf.Len() <= thumbMaxSize {
+			return buf.Bytes(), nil
+		}
+	}
+
+	return nil, errors.Errorf("thumbnail is %d bytes", buf.Len())
+}
+
+// Thumbnail downloads the image and makes a thumbnail of it
+func (d *Downloader) Thumbnail(ctx context.Context, url string) ([]byte, error) {
+	resp, err := d.httpClient.R().SetContext(ctx).SetDoNotParseResponse(true).Get(url)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "get image")
+	}
+
+	body := resp.RawBody()
+	defer body.Close()
+
+	if !resp.IsSuccess() {
+		return nil, errors.Errorf("status %d", resp.StatusCode())
+	}
+
+	return makeThumb(io.LimitReader(d.limitReader(ctx, body), posterMaxSize))
+}
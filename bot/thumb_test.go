@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeThumb(t *testing.T) {
+	// noise compresses badly
+	src := image.NewRGBA(image.Rect(0, 0, 1280, 720))
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := range src.Pix {
+		src.Pix[i] = uint8(rnd.Intn(256))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	data, err := makeThumb(&buf)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(data), thumbMaxSize)
+
+	thumb, err := jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 320, 180), thumb.Bounds())
+
+	// small images are not scaled up
+	small := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	small.Set(0, 0, color.White)
+
+	buf.Reset()
+	require.NoError(t, png.Encode(&buf, small))
+
+	data, err = makeThumb(&buf)
+	require.NoError(t, err)
+
+	thumb, err = jpeg.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 100, 200), thumb.Bounds())
+
+	_, err = makeThumb(bytes.NewReader([]byte("not an image")))
+	require.Error(t, err)
+}
@@ -1,38 +1,376 @@
 package bot
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+type UserStorageKind string
+
+const (
+	UserStorageKindMemory UserStorageKind = "memory"
+	UserStorageKindJSON   UserStorageKind = "json"
+	UserStorageKindBolt   UserStorageKind = "bolt"
 )
 
-// not implemented
+// UserStorage keeps users data between restarts
+type UserStorage interface {
+	// Get returns false if the user is not stored
+	Get(userID int64) (*UserData, bool, error)
+	// Update calls fn with the user data (empty if not stored) and stores the result
+	Update(userID int64, fn func(*UserData)) (*UserData, error)
+	// All returns all the users sorted by id
+	All() ([]*UserData, error)
+	Close() error
+}
+
+func defaultUserStorageFile(kind UserStorageKind) string {
+	if kind == UserStorageKindBolt {
+		return "users.db"
+	}
+	return "users.json"
+}
+
+func NewUserStorage(kind UserStorageKind, filepath string) (UserStorage, error) {
+	switch kind {
+	case UserStorageKindMemory:
+		return NewUserStorageMemory(), nil
+	case UserStorageKindJSON:
+		return NewUserStorageJSON(filepath)
+	case UserStorageKindBolt:
+		return NewUserStorageBolt(filepath)
+	}
+	return nil, errors.Errorf("unknown user storage: %s", kind)
+}
+
+func copyUserData(data *UserData) *UserData {
+	c := *data
+	c.Quota = data.Quota.copy()
+	if data.Transfers != nil {
+		c.Transfers = make([]DailyTransfer, len(data.Transfers))
+		copy(c.Transfers, data.Transfers)
+	}
+	return &c
+}
+
+// UserStorageMemory doesn't persist anything
+type UserStorageMemory struct {
+	users map[int64]*UserData
+	mutex *sync.Mutex
+}
+
+func NewUserStorageMemory() *UserStorageMemory {
+	return &UserStorageMemory{
+		users: make(map[int64]*UserData),
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (us *UserStorageMemory) Get(userID int64) (*UserData, bool, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	data, ok := us.users[userID]
+	if !ok {
+		return nil, false, nil
+	}
+	return copyUserData(data), true, nil
+}
+
+func (us *UserStorageMemory) Update(userID int64, fn func(*UserData)) (*UserData, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	data, ok := us.users[userID]
+	if !ok {
+		data = &UserData{UserID: userID}
+		us.users[userID] = data
+	}
+
+	fn(data)
+
+	return copyUserData(data), nil
+}
+
+func (us *UserStorageMemory) All() ([]*UserData, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	return sortedUsers(us.users), nil
+}
 
+func (us *UserStorageMemory) Close() error {
+	return nil
+}
+
+func sortedUsers(users map[int64]*UserData) []*UserData {
+	res := make([]*UserData, 0, len(users))
+	for _, data := range users {
+		res = append(res, copyUserData(data))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res
+}
+
+// changes of UserData.LastSeen only are written at most once in the interval
+const lastSeenSaveInterval = time.Minute
+
+// UserStorageJSON keeps users in memory and rewrites the whole file on the updates that change something
 type UserStorageJSON struct {
 	Filepath string
 	Users    map[int64]*UserData
 	mutex    *sync.Mutex
+
+	// not saved changes of LastSeen
+	dirty     bool
+	lastSaved time.Time
 }
 
-func NewUserStorageJSON(filepath string) *UserStorageJSON {
-	panic("not implemented")
-	return nil
+func NewUserStorageJSON(filepath string) (*UserStorageJSON, error) {
+	us := &UserStorageJSON{
+		Filepath: filepath,
+		Users:    make(map[int64]*UserData),
+		mutex:    &sync.Mutex{},
+	}
+
+	if err := us.Load(); err != nil {
+		return nil, errors.Wrap(err, "load users")
+	}
+
+	return us, nil
 }
 
 func (us *UserStorageJSON) Load() error {
-	return nil
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	data, err := os.ReadFile(us.Filepath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	return json.Unmarshal(data, &us.Users)
 }
 
 func (us *UserStorageJSON) Save() error {
-	return nil
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	return us.saveLocked()
 }
 
-func (us *UserStorageJSON) Get(userID int64) *UserData {
+func (us *UserStorageJSON) saveLocked() error {
+	data, err := json.MarshalIndent(us.Users, "", "  ")
+
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	if err := writeFileAtomic(us.Filepath, data, 0644); err != nil {
+		return err
+	}
+
+	us.dirty = false
+	us.lastSaved = time.Now()
+
 	return nil
 }
 
-func (us *UserStorageJSON) Set(userID int64, data *UserData) error {
-	return nil
+func (us *UserStorageJSON) Get(userID int64) (*UserData, bool, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	data, ok := us.Users[userID]
+	if !ok {
+		return nil, false, nil
+	}
+	return copyUserData(data), true, nil
 }
 
-func (us *UserStorageJSON) IncQueries(userID int64) error {
-	return nil
+func (us *UserStorageJSON) Update(userID int64, fn func(*UserData)) (*UserData, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	data, ok := us.Users[userID]
+	if !ok {
+		data = &UserData{UserID: userID}
+		us.Users[userID] = data
+	}
+
+	before := copyUserData(data)
+
+	fn(data)
+
+	if ok && !us.changedLocked(before, data) {
+		return copyUserData(data), nil
+	}
+
+	if err := us.saveLocked(); err != nil {
+		return nil, errors.Wrap(err, "save users")
+	}
+
+	return copyUserData(data), nil
+}
+
+// reports whether the update has to be written. A new LastSeen only is kept in memory
+// until the interval passes or something else changes
+func (us *UserStorageJSON) changedLocked(before, after *UserData) bool {
+	probe := copyUserData(after)
+	probe.LastSeen = before.LastSeen
+
+	beforeData, err1 := json.Marshal(before)
+	probeData, err2 := json.Marshal(probe)
+
+	if err1 != nil || err2 != nil || !bytes.Equal(beforeData, probeData) {
+		return true
+	}
+
+	if after.LastSeen.Equal(before.LastSeen) {
+		return false
+	}
+
+	us.dirty = true
+
+	return time.Since(us.lastSaved) >= lastSeenSaveInterval
+}
+
+func (us *UserStorageJSON) All() ([]*UserData, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	return sortedUsers(us.Users), nil
+}
+
+func (us *UserStorageJSON) Close() error {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if !us.dirty {
+		return nil
+	}
+
+	return us.saveLocked()
+}
+
+var boltUsersBucket = []byte("users")
+
+// time to wait for the database file lock
+const defaultBoltTimeout = 5 * time.Second
+
+// UserStorageBolt keeps users in a bolt database
+type UserStorageBolt struct {
+	db *bolt.DB
+}
+
+func NewUserStorageBolt(filepath string) (*UserStorageBolt, error) {
+	db, err := bolt.Open(filepath, 0600, &bolt.Options{Timeout: defaultBoltTimeout})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "open bolt db")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltUsersBucket)
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "create users bucket")
+	}
+
+	return &UserStorageBolt{db: db}, nil
+}
+
+func boltUserKey(userID int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(userID))
+	return key
+}
+
+func (us *UserStorageBolt) Get(userID int64) (*UserData, bool, error) {
+	var data *UserData
+
+	err := us.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltUsersBucket).Get(boltUserKey(userID))
+		if v == nil {
+			return nil
+		}
+		data = &UserData{}
+		return json.Unmarshal(v, data)
+	})
+
+	if err != nil {
+		return nil, false, errors.Wrap(err, "get user")
+	}
+
+	return data, data != nil, nil
+}
+
+func (us *UserStorageBolt) Update(userID int64, fn func(*UserData)) (*UserData, error) {
+	data := &UserData{UserID: userID}
+
+	err := us.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltUsersBucket)
+		key := boltUserKey(userID)
+
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, data); err != nil {
+				return errors.Wrap(err, "unmarshal")
+			}
+		}
+
+		fn(data)
+
+		v, err := json.Marshal(data)
+		if err != nil {
+			return errors.Wrap(err, "marshal")
+		}
+
+		return b.Put(key, v)
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "update user")
+	}
+
+	return data, nil
+}
+
+func (us *UserStorageBolt) All() ([]*UserData, error) {
+	var res []*UserData
+
+	err := us.db.View(func(tx *bolt.Tx) error {
+		// keys are big endian so the users are sorted by id
+		return tx.Bucket(boltUsersBucket).ForEach(func(k, v []byte) error {
+			data := &UserData{}
+			if err := json.Unmarshal(v, data); err != nil {
+				return errors.Wrap(err, "unmarshal")
+			}
+			res = append(res, data)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "list users")
+	}
+
+	return res, nil
+}
+
+func (us *UserStorageBolt) Close() error {
+	return us.db.Close()
 }
@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testUserStorage(t *testing.T, open func() UserStorage) {
+	us := open()
+
+	_, ok, err := us.Get(1)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	seen := time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC)
+
+	data, err := us.Update(2, func(data *UserData) {
+		data.Username = "user"
+		data.FirstSeen = seen
+		data.Card = CardThemeDark.Name
+		data.Transfers = append(data.Transfers, DailyTransfer{Day: "2024-01-04", Downloaded: 10, Uploaded: 20})
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), data.UserID)
+
+	// the returned data is a copy
+	data.Transfers[0].Downloaded = 0
+
+	_, err = us.Update(1, func(data *UserData) { data.QueriesTotal = 5 })
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := us.Update(1, func(data *UserData) { data.QueriesTotal++ })
+			require.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+	require.NoError(t, us.Close())
+
+	us = open()
+	defer us.Close()
+
+	data, ok, err = us.Get(2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "user", data.Username)
+	require.Equal(t, seen, data.FirstSeen.UTC())
+	require.Equal(t, CardThemeDark.Name, data.Card)
+	require.Equal(t, []DailyTransfer{{Day: "2024-01-04", Downloaded: 10, Uploaded: 20}}, data.Transfers)
+
+	all, err := us.All()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, int64(1), all[0].UserID)
+	require.Equal(t, 25, all[0].QueriesTotal)
+}
+
+func TestUserStorageJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	testUserStorage(t, func() UserStorage {
+		us, err := NewUserStorageJSON(path)
+		require.NoError(t, err)
+		return us
+	})
+}
+
+func TestUserStorageBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+
+	testUserStorage(t, func() UserStorage {
+		us, err := NewUserStorageBolt(path)
+		require.NoError(t, err)
+		return us
+	})
+}
+
+func TestUserStorageJSONLastSeen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	us, err := NewUserStorageJSON(path)
+	require.NoError(t, err)
+
+	seen := time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC)
+
+	_, err = us.Update(1, func(data *UserData) { data.LastSeen = seen })
+	require.NoError(t, err)
+
+	// only the last seen time changed, it's written later
+	_, err = us.Update(1, func(data *UserData) { data.LastSeen = seen.Add(time.Second) })
+	require.NoError(t, err)
+
+	stored, err := NewUserStorageJSON(path)
+	require.NoError(t, err)
+	require.Equal(t, seen, stored.Users[1].LastSeen.UTC())
+
+	require.NoError(t, us.Close())
+
+	stored, err = NewUserStorageJSON(path)
+	require.NoError(t, err)
+	require.Equal(t, seen.Add(time.Second), stored.Users[1].LastSeen.UTC())
+}
@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-faster/errors"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"go.uber.org/zap"
+)
+
+// VariantLimits restricts the video variant that is sent. Zero means no limit
+type VariantLimits struct {
+	MaxSize int64
+	// limit of the shorter side, 720 for 720p
+	MaxResolution int
+}
+
+// telegram limit for files uploaded by bots through mtproto
+const telegramMaxFileSize = 2000 << 20
+
+func DefaultVariantLimits() VariantLimits {
+	return VariantLimits{MaxSize: telegramMaxFileSize}
+}
+
+// SizedVariant is a video variant with the size from its headers. Size is -1 if unknown
+type SizedVariant struct {
+	twitter.VideoVariant
+	Size int64
+}
+
+func (v SizedVariant) String() string {
+	s := fmt.Sprintf("%d kbps", v.Bitrate/1000)
+
+	if w, h, ok := v.Resolution(); ok {
+		s = fmt.Sprintf("%dx%d, %s", w, h, s)
+	}
+
+	if v.Size >= 0 {
+		s += ", " + formatBytes(v.Size)
+	}
+
+	return s
+}
+
+// OversizedVideo is a video without a variant within the limits
+type OversizedVideo struct {
+	MediaKey string
+	Best     SizedVariant
+	// the smallest variant. Same as Best if there is only one
+	Smaller SizedVariant
+}
+
+// Size requests the size of the url with HEAD. Returns -1 if the server doesn't tell
+func (d *Downloader) Size(ctx context.Context, url string) (int64, error) {
+	resp, err := d.httpClient.R().SetContext(ctx).Head(url)
+
+	if err != nil {
+		return 0, errors.Wrap(err, "head")
+	}
+
+	if resp.IsError() {
+		return 0, errors.Errorf("head status %d", resp.StatusCode())
+	}
+
+	return resp.RawResponse.ContentLength, nil
+}
+
+func shortSide(v twitter.VideoVariant) (int, bool) {
+	w, h, ok := v.Resolution()
+	return min(w, h), ok
+}
+
+// SelectVideoVariants picks for each video the best variant within the limits. The returned
+// tweet data has only the picked variants. Videos without such variant are removed from it
+func (d *Downloader) SelectVideoVariants(ctx context.Context, td *twitter.TweetData, limits VariantLimits) (*twitter.TweetData, []OversizedVideo) {
+	selected := *td
+	selected.Videos = make([]twitter.Video, 0, len(td.Videos))
+
+	var oversized []OversizedVideo
+
+	for _, v := range td.Videos {
+		variants := make([]twitter.VideoVariant, 0, len(v.Variants))
+
+		for _, vv := range v.Variants {
+			if vv.IsMP4() {
+				variants = append(variants, vv)
+			}
+		}
+
+		if len(variants) == 0 {
+			continue
+		}
+
+		sort.SliceStable(variants, func(i, j int) bool { return variants[i].Bitrate > variants[j].Bitrate })
+
+		sizes := make([]int64, len(variants))
+		picked := -1
+
+		for i, vv := range variants {
+			sizes[i] = -1
+
+			if side, ok := shortSide(vv); ok && limits.MaxResolution > 0 && side > limits.MaxResolution {
+				continue
+			}
+
+			if limits.MaxSize > 0 {
+				size, err := d.Size(ctx, vv.URL())
+				if err != nil {
+					d.logger.Warn("failed to get variant size", zap.String("url", vv.URL()), zap.Error(err))
+				} else {
+					sizes[i] = size
+				}
+			}
+
+			// unknown size is given a chance
+			if limits.MaxSize > 0 && sizes[i] > limits.MaxSize {
+				continue
+			}
+
+			picked = i
+			break
+		}
+
+		if picked < 0 {
+			last := len(variants) - 1
+			if sizes[last] < 0 && limits.MaxSize > 0 {
+				sizes[last], _ = d.Size(ctx, variants[last].URL())
+			}
+
+			ov := OversizedVideo{
+				MediaKey: v.MediaKey,
+				Best:     SizedVariant{VideoVariant: variants[0], Size: sizes[0]},
+				Smaller:  SizedVariant{VideoVariant: variants[last], Size: sizes[last]},
+			}
+
+			d.logger.Info("No video variant within limits",
+				zap.String("mediaKey", v.MediaKey),
+				zap.Stringer("best", ov.Best),
+				zap.Stringer("smaller", ov.Smaller),
+				zap.Int64("maxSize", limits.MaxSize),
+				zap.Int("maxResolution", limits.MaxResolution),
+			)
+
+			oversized = append(oversized, ov)
+			continue
+		}
+
+		chosen := SizedVariant{VideoVariant: variants[picked], Size: sizes[picked]}
+
+		d.logger.Info("Selected video variant",
+			zap.String("mediaKey", v.MediaKey),
+			zap.Stringer("variant", chosen),
+			zap.Int("skipped", picked),
+			zap.String("url", chosen.URL()),
+		)
+
+		v.Variants = twitter.VideoVariants{chosen.VideoVariant}
+		selected.Videos = append(selected.Videos, v)
+	}
+
+	return &selected, oversized
+}
@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectVideoVariants(t *testing.T) {
+	sizes := map[string]int{
+		"/vid/avc1/1920x1080/a.mp4": 300 << 20,
+		"/vid/avc1/1280x720/a.mp4":  100 << 20,
+		"/vid/avc1/640x360/a.mp4":   20 << 20,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(sizes[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	td := &twitter.TweetData{
+		Videos: []twitter.Video{{
+			MediaKey: "7_1",
+			Variants: twitter.VideoVariants{
+				{Bitrate: 832000, ContentType: "video/mp4", VideoURL: server.URL + "/vid/avc1/640x360/a.mp4"},
+				{Bitrate: 10368000, ContentType: "video/mp4", VideoURL: server.URL + "/vid/avc1/1920x1080/a.mp4"},
+				{ContentType: "application/x-mpegURL", VideoURL: server.URL + "/pl/a.m3u8"},
+				{Bitrate: 2176000, ContentType: "video/mp4", VideoURL: server.URL + "/vid/avc1/1280x720/a.mp4"},
+			},
+		}},
+	}
+
+	d := NewDownloader()
+
+	selected, oversized := d.SelectVideoVariants(context.Background(), td, VariantLimits{MaxSize: 200 << 20})
+	require.Empty(t, oversized)
+	require.Len(t, selected.Videos, 1)
+	require.Equal(t, 2176000, selected.Videos[0].Variants[0].Bitrate)
+	// the original is not changed
+	require.Len(t, td.Videos[0].Variants, 4)
+
+	selected, _ = d.SelectVideoVariants(context.Background(), td, VariantLimits{MaxResolution: 360})
+	require.Equal(t, 832000, selected.Videos[0].Variants[0].Bitrate)
+
+	selected, oversized = d.SelectVideoVariants(context.Background(), td, VariantLimits{MaxSize: 10 << 20})
+	require.Empty(t, selected.Videos)
+	require.Len(t, oversized, 1)
+	require.Equal(t, int64(300<<20), oversized[0].Best.Size)
+	require.Equal(t, int64(20<<20), oversized[0].Smaller.Size)
+}
@@ -2,9 +2,12 @@ package bot
 
 import (
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/nktknshn/go-twitter-download-bot/bot"
 	"github.com/nktknshn/go-twitter-download-bot/cli/logging"
+	"github.com/nktknshn/go-twitter-download-bot/twitter"
 	"github.com/spf13/cobra"
 )
 
@@ -22,12 +25,91 @@ var (
 	flagIncludeURL     bool
 	flagIncludeBotName bool
 
-	flagLimitPending int = 1
-	flagLimitPerDay  int = 30
+	flagLimitPending   int = 1
+	flagLimitPerDay    int = 30
+	flagLimitPerMinute int
+	flagLimitPerHour   int
+	flagLimitPerWeek   int
+	flagLimitBytes     string
+	flagQuotaAlgorithm string = string(bot.QuotaSlidingWindow)
+	flagTiers          []string
+
+	flagXLimitToken   int = twitter.DefaultRateLimits().Token
+	flagXLimitGraphQL int = twitter.DefaultRateLimits().GraphQL
+	flagXLimitMedia   int = twitter.DefaultRateLimits().Media
+
+	flagBreakerFailures int           = twitter.DefaultBreakerSettings().FailureThreshold
+	flagBreakerTimeout  time.Duration = twitter.DefaultBreakerSettings().OpenTimeout
+	flagBreakerProbes   int           = twitter.DefaultBreakerSettings().HalfOpenProbes
+
+	flagNoCache       bool
+	flagCacheTTL      time.Duration = twitter.DefaultCacheOptions().TTL
+	flagCacheSize     int           = twitter.DefaultCacheOptions().Size
+	flagCacheDir      string
+	flagCacheDiskSize int = twitter.DefaultCacheOptions().DiskSize
+
+	flagFileRefsFile string
+
+	flagUserStorage     string = string(bot.UserStorageKindBolt)
+	flagUserStorageFile string
+
+	flagWorkers int = 4
+
+	flagTimeoutFetch    time.Duration = bot.DefaultStageTimeouts().Fetch
+	flagTimeoutDownload time.Duration = bot.DefaultStageTimeouts().Download
+	flagTimeoutUpload   time.Duration = bot.DefaultStageTimeouts().Upload
+
+	flagDownloadsPerJob int = 4
+	flagDownloadsGlobal int = 16
+
+	flagStreaming bool
+
+	flagVideoMaxSize       string = "2000MB"
+	flagVideoMaxResolution int
+
+	flagCollageGutter  int    = bot.DefaultCollageOptions().Gutter
+	flagCollageMaxSide int    = bot.DefaultCollageOptions().MaxSide
+	flagCollageMaxSize string = "10MB"
+	flagForwardCollage bool
+
+	flagCardWidth    int = bot.DefaultCardOptions().Width
+	flagCardMaxLines int = bot.DefaultCardOptions().MaxLines
+	flagForwardCard  string
+
+	flagDeleteAfterUpload bool
+	flagMaxFolderSize     string
+	flagMaxFileAge        time.Duration
+	flagSweepInterval     time.Duration = bot.DefaultRetentionOptions().SweepInterval
+	flagAllParts          bool
+
+	flagFilenameTemplate string = bot.DefaultFilenameTemplate
+
+	flagArchive     string = string(bot.MediaStoreKindNone)
+	flagArchiveDir  string
+	flagS3Endpoint  string
+	flagS3Bucket    string
+	flagS3AccessKey string
+	flagS3SecretKey string
+	flagS3Region    string
+	flagS3Prefix    string
+	flagS3SSL       bool = true
+
+	flagDedup bool
+
+	flagMaxConnsPerHost int           = twitter.DefaultTransportOptions().MaxConnsPerHost
+	flagDNSCacheTTL     time.Duration = twitter.DefaultTransportOptions().DNSCacheTTL
+	flagBandwidth       string
+	flagBandwidthJob    string
 )
 
 func init() {
 	Cmd.AddCommand(cmdStart)
+	Cmd.AddCommand(cmdGC)
+
+	cmdGC.Flags().StringVarP(&flagDownloadFolder, "download-folder", "d", "", "download folder")
+	cmdGC.Flags().StringVar(&flagMaxFolderSize, "max-folder-size", "", "remove the least recently used media over the total size like 10GB")
+	cmdGC.Flags().DurationVar(&flagMaxFileAge, "max-file-age", 0, "remove media not used for the duration")
+	cmdGC.Flags().BoolVar(&flagAllParts, "all-parts", false, "remove all partially downloaded and temporary files, not only abandoned ones. Use when the bot is stopped")
 
 	cmdStart.PersistentFlags().Int64VarP(&flagAdminID, "admin-id", "a", 0, "admin id")
 	cmdStart.PersistentFlags().BoolVarP(&flagRestrictToAdminID, "restrict-to-admin-id", "r", flagRestrictToAdminID, "Restrict usage to admin id")
@@ -44,7 +126,68 @@ func init() {
 
 	cmdStart.PersistentFlags().IntVarP(&flagLimitPending, "limit-pending", "p", flagLimitPending, "limit pending requests from a user (admin has no limit)")
 
-	cmdStart.PersistentFlags().IntVarP(&flagLimitPerDay, "limit-per-day", "L", flagLimitPerDay, "limit requests per day (admin has no limit). Will reset after restart or next day.")
+	cmdStart.PersistentFlags().IntVarP(&flagLimitPerDay, "limit-per-day", "L", flagLimitPerDay, "limit requests per day (admin has no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagLimitPerMinute, "limit-per-minute", 0, "limit requests per minute (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagLimitPerHour, "limit-per-hour", 0, "limit requests per hour (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagLimitPerWeek, "limit-per-week", 0, "limit requests per week (0 for no limit)")
+	cmdStart.PersistentFlags().StringVar(&flagLimitBytes, "limit-bytes-per-day", "", "limit bytes downloaded and uploaded per day like 500MB (empty for no limit)")
+	cmdStart.PersistentFlags().StringVar(&flagQuotaAlgorithm, "quota-algorithm", flagQuotaAlgorithm, "how limits are counted: sliding or bucket")
+	cmdStart.PersistentFlags().StringArrayVar(&flagTiers, "tier", nil, "user tier limits like trusted=minute:5,day:100,bytes-day:1GB or premium=unlimited. Can be repeated")
+
+	cmdStart.PersistentFlags().StringVar(&flagUserStorage, "user-storage", flagUserStorage, "where to keep users data: json, bolt or memory")
+	cmdStart.PersistentFlags().StringVar(&flagUserStorageFile, "user-storage-file", "", "users data file (default download-folder/users.json or users.db)")
+
+	cmdStart.PersistentFlags().IntVar(&flagXLimitToken, "x-limit-token", flagXLimitToken, "limit requests per minute to X pages used to get tokens (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagXLimitGraphQL, "x-limit-graphql", flagXLimitGraphQL, "limit requests per minute to X graphql api (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagXLimitMedia, "x-limit-media", flagXLimitMedia, "limit requests per minute to X media hosts (0 for no limit)")
+
+	cmdStart.PersistentFlags().IntVar(&flagBreakerFailures, "breaker-failures", flagBreakerFailures, "consecutive X failures to stop sending requests to X")
+	cmdStart.PersistentFlags().DurationVar(&flagBreakerTimeout, "breaker-timeout", flagBreakerTimeout, "time to wait before probing X again")
+	cmdStart.PersistentFlags().IntVar(&flagBreakerProbes, "breaker-probes", flagBreakerProbes, "successful probe requests to resume sending requests to X")
+
+	cmdStart.PersistentFlags().BoolVar(&flagNoCache, "no-cache", false, "disable tweet data cache")
+	cmdStart.PersistentFlags().DurationVar(&flagCacheTTL, "cache-ttl", flagCacheTTL, "how long tweet data is cached")
+	cmdStart.PersistentFlags().IntVar(&flagCacheSize, "cache-size", flagCacheSize, "max number of tweets cached in memory")
+	cmdStart.PersistentFlags().StringVar(&flagCacheDir, "cache-dir", "", "persist tweet data cache to the directory (optional)")
+	cmdStart.PersistentFlags().IntVar(&flagCacheDiskSize, "cache-disk-size", flagCacheDiskSize, "max number of tweets kept in the cache dir (0 for no limit)")
+
+	cmdStart.PersistentFlags().IntVar(&flagWorkers, "workers", flagWorkers, "number of requests processed at the same time")
+	cmdStart.PersistentFlags().IntVar(&flagDownloadsPerJob, "download-concurrency", flagDownloadsPerJob, "concurrent media downloads of a tweet (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagDownloadsGlobal, "download-concurrency-global", flagDownloadsGlobal, "concurrent media downloads of all requests (0 for no limit)")
+	cmdStart.PersistentFlags().BoolVar(&flagStreaming, "stream", false, "upload media to telegram while downloading it, files are saved only when needed")
+	cmdStart.PersistentFlags().StringVar(&flagVideoMaxSize, "video-max-size", flagVideoMaxSize, "send the best video variant up to the size, links are sent if none fits (empty for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagVideoMaxResolution, "video-max-resolution", 0, "send the best video variant up to the resolution like 720 (0 for no limit)")
+	cmdStart.PersistentFlags().IntVar(&flagCollageGutter, "collage-gutter", flagCollageGutter, "space between the photos of a collage in pixels")
+	cmdStart.PersistentFlags().IntVar(&flagCollageMaxSide, "collage-max-side", flagCollageMaxSide, "limit of the longer side of a collage in pixels")
+	cmdStart.PersistentFlags().StringVar(&flagCollageMaxSize, "collage-max-size", flagCollageMaxSize, "limit of the collage jpeg, the quality and the size are lowered to fit")
+	cmdStart.PersistentFlags().BoolVar(&flagForwardCollage, "forward-collage", false, "send tweets with 2-4 photos to the forward channel as a collage")
+	cmdStart.PersistentFlags().IntVar(&flagCardWidth, "card-width", flagCardWidth, "width of the images of tweets without media in pixels")
+	cmdStart.PersistentFlags().IntVar(&flagCardMaxLines, "card-max-lines", flagCardMaxLines, "lines of the tweet text on the image, longer texts are cut")
+	cmdStart.PersistentFlags().StringVar(&flagForwardCard, "forward-card", "", "send tweets without media to the forward channel as images: light or dark (empty to not send them)")
+	cmdStart.PersistentFlags().StringVar(&flagFilenameTemplate, "filename-template", flagFilenameTemplate, "go template of media file names, slashes make subdirectories. Fields: Author, TweetID, Date, Index, Kind, MediaKey, Resolution, Name, Ext")
+	cmdStart.PersistentFlags().StringVar(&flagArchive, "archive", flagArchive, "archive downloaded media with tweet metadata: none, local or s3")
+	cmdStart.PersistentFlags().StringVar(&flagArchiveDir, "archive-dir", "", "archive folder for local archive, outside of the download folder")
+	cmdStart.PersistentFlags().StringVar(&flagS3Endpoint, "s3-endpoint", "", "s3 endpoint like localhost:9000")
+	cmdStart.PersistentFlags().StringVar(&flagS3Bucket, "s3-bucket", "", "s3 bucket, created if missing")
+	cmdStart.PersistentFlags().StringVar(&flagS3AccessKey, "s3-access-key", "", "s3 access key (default $S3_ACCESS_KEY)")
+	cmdStart.PersistentFlags().StringVar(&flagS3SecretKey, "s3-secret-key", "", "s3 secret key (default $S3_SECRET_KEY)")
+	cmdStart.PersistentFlags().StringVar(&flagS3Region, "s3-region", "", "s3 region (optional)")
+	cmdStart.PersistentFlags().StringVar(&flagS3Prefix, "s3-prefix", "", "prefix of the archived objects (optional)")
+	cmdStart.PersistentFlags().BoolVar(&flagS3SSL, "s3-ssl", flagS3SSL, "use https for s3")
+	cmdStart.PersistentFlags().BoolVar(&flagDedup, "dedup", false, "store media once by content hash and skip downloading media stored before")
+	cmdStart.PersistentFlags().IntVar(&flagMaxConnsPerHost, "max-conns-per-host", flagMaxConnsPerHost, "connections to a host of X (0 for no limit)")
+	cmdStart.PersistentFlags().DurationVar(&flagDNSCacheTTL, "dns-cache-ttl", flagDNSCacheTTL, "cache resolved addresses for the duration (0 to disable)")
+	cmdStart.PersistentFlags().StringVar(&flagBandwidth, "bandwidth", "", "download speed limit per second like 5MB (empty for no limit)")
+	cmdStart.PersistentFlags().StringVar(&flagBandwidthJob, "bandwidth-per-request", "", "download speed limit per second of a request like 1MB (empty for no limit)")
+	cmdStart.PersistentFlags().BoolVar(&flagDeleteAfterUpload, "delete-after-upload", false, "remove downloaded media once it's sent")
+	cmdStart.PersistentFlags().StringVar(&flagMaxFolderSize, "max-folder-size", "", "remove the least recently used media over the total size like 10GB (empty for no limit)")
+	cmdStart.PersistentFlags().DurationVar(&flagMaxFileAge, "max-file-age", 0, "remove media not used for the duration (0 for no limit)")
+	cmdStart.PersistentFlags().DurationVar(&flagSweepInterval, "sweep-interval", flagSweepInterval, "how often the download folder is cleaned up (0 to clean up only at start)")
+	cmdStart.PersistentFlags().DurationVar(&flagTimeoutFetch, "timeout-fetch", flagTimeoutFetch, "time limit for getting tweet data from X (0 for no limit)")
+	cmdStart.PersistentFlags().DurationVar(&flagTimeoutDownload, "timeout-download", flagTimeoutDownload, "time limit for downloading tweet media (0 for no limit)")
+	cmdStart.PersistentFlags().DurationVar(&flagTimeoutUpload, "timeout-upload", flagTimeoutUpload, "time limit for uploading media to telegram (0 for no limit)")
+
+	cmdStart.PersistentFlags().StringVar(&flagFileRefsFile, "file-refs-file", "", "file to keep references to uploaded media in (default download-folder/file_refs.jsonl)")
 
 }
 
@@ -61,11 +204,126 @@ var cmdStart = &cobra.Command{
 	RunE:  runStart,
 }
 
+var cmdGC = &cobra.Command{
+	Use:   "gc",
+	Short: "remove media from the download folder",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runGC,
+}
+
+func retentionOptions() (bot.RetentionOptions, error) {
+	opts := bot.RetentionOptions{
+		DeleteAfterUpload: flagDeleteAfterUpload,
+		MaxAge:            flagMaxFileAge,
+		SweepInterval:     flagSweepInterval,
+	}
+
+	if flagMaxFolderSize != "" {
+		var err error
+		if opts.MaxTotalSize, err = bot.ParseByteSize(flagMaxFolderSize); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+func envDefault(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+func runGC(cmd *cobra.Command, args []string) error {
+	if flagDownloadFolder == "" {
+		return fmt.Errorf("download folder is required")
+	}
+
+	retention, err := retentionOptions()
+	if err != nil {
+		return err
+	}
+
+	sweeper := bot.NewSweeper(flagDownloadFolder, retention, logger)
+
+	result, err := sweeper.Sweep(flagAllParts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d files, freed %d bytes. Remaining %d files, %d bytes\n",
+		result.Removed, result.Freed, result.Remaining, result.RemainingSize)
+
+	return nil
+}
+
 func runStart(cmd *cobra.Command, args []string) error {
 	if flagDownloadFolder == "" {
 		return fmt.Errorf("download folder is required")
 	}
 
+	tiers := make([]bot.Tier, len(flagTiers))
+
+	for i, spec := range flagTiers {
+		tier, err := bot.ParseTier(spec)
+		if err != nil {
+			return err
+		}
+		tiers[i] = tier
+	}
+
+	var limitBytes int64
+
+	if flagLimitBytes != "" {
+		var err error
+		if limitBytes, err = bot.ParseByteSize(flagLimitBytes); err != nil {
+			return err
+		}
+	}
+
+	var videoMaxSize int64
+
+	if flagVideoMaxSize != "" {
+		var err error
+		if videoMaxSize, err = bot.ParseByteSize(flagVideoMaxSize); err != nil {
+			return err
+		}
+	}
+
+	collageMaxSize, err := bot.ParseByteSize(flagCollageMaxSize)
+	if err != nil {
+		return err
+	}
+
+	retention, err := retentionOptions()
+	if err != nil {
+		return err
+	}
+
+	var bandwidth bot.BandwidthLimits
+
+	if flagBandwidth != "" {
+		if bandwidth.Global, err = bot.ParseByteSize(flagBandwidth); err != nil {
+			return err
+		}
+	}
+
+	if flagBandwidthJob != "" {
+		if bandwidth.PerJob, err = bot.ParseByteSize(flagBandwidthJob); err != nil {
+			return err
+		}
+	}
+
+	transport := twitter.DefaultTransportOptions()
+	transport.MaxConnsPerHost = flagMaxConnsPerHost
+	transport.DNSCacheTTL = flagDNSCacheTTL
+
+	filenameTemplate, err := bot.ParseFilenameTemplate(flagFilenameTemplate)
+	if err != nil {
+		return err
+	}
+
 	logger.Info("Starting bot")
 
 	return bot.Run(
@@ -78,5 +336,67 @@ func runStart(cmd *cobra.Command, args []string) error {
 		bot.WithSessionFile(flagSessionFile),
 		bot.WithPostSettings(flagIncludeText, flagIncludeURL, flagIncludeBotName),
 		bot.WithLimits(flagLimitPerDay, flagLimitPending),
+		bot.WithWindowLimits(flagLimitPerMinute, flagLimitPerHour, flagLimitPerWeek),
+		bot.WithBytesLimit(limitBytes),
+		bot.WithQuota(bot.QuotaAlgorithm(flagQuotaAlgorithm), tiers...),
+		bot.WithTwitterRateLimits(twitter.RateLimits{
+			Token:   flagXLimitToken,
+			GraphQL: flagXLimitGraphQL,
+			Media:   flagXLimitMedia,
+		}),
+		bot.WithBreakerSettings(twitter.BreakerSettings{
+			FailureThreshold: flagBreakerFailures,
+			OpenTimeout:      flagBreakerTimeout,
+			HalfOpenProbes:   flagBreakerProbes,
+		}),
+		bot.WithTweetCache(!flagNoCache, twitter.CacheOptions{
+			TTL:      flagCacheTTL,
+			Size:     flagCacheSize,
+			Dir:      flagCacheDir,
+			DiskSize: flagCacheDiskSize,
+		}),
+		bot.WithFileRefsFile(flagFileRefsFile),
+		bot.WithUserStorage(bot.UserStorageKind(flagUserStorage), flagUserStorageFile),
+		bot.WithWorkers(flagWorkers),
+		bot.WithDownloadConcurrency(flagDownloadsPerJob, flagDownloadsGlobal),
+		bot.WithStreaming(flagStreaming),
+		bot.WithRetention(retention),
+		bot.WithFilenames(filenameTemplate),
+		bot.WithDedup(flagDedup),
+		bot.WithTransport(transport),
+		bot.WithBandwidth(bandwidth),
+		bot.WithMediaStore(bot.MediaStoreOptions{
+			Kind: bot.MediaStoreKind(flagArchive),
+			Dir:  flagArchiveDir,
+			S3: bot.S3Options{
+				Endpoint:  flagS3Endpoint,
+				Bucket:    flagS3Bucket,
+				AccessKey: envDefault(flagS3AccessKey, "S3_ACCESS_KEY"),
+				SecretKey: envDefault(flagS3SecretKey, "S3_SECRET_KEY"),
+				Region:    flagS3Region,
+				Prefix:    flagS3Prefix,
+				UseSSL:    flagS3SSL,
+			},
+		}),
+		bot.WithVariantLimits(bot.VariantLimits{
+			MaxSize:       videoMaxSize,
+			MaxResolution: flagVideoMaxResolution,
+		}),
+		bot.WithCollage(bot.CollageOptions{
+			Gutter:  flagCollageGutter,
+			MaxSide: flagCollageMaxSide,
+			MaxSize: collageMaxSize,
+		}),
+		bot.WithForwardCollage(flagForwardCollage),
+		bot.WithCard(bot.CardOptions{
+			Width:    flagCardWidth,
+			MaxLines: flagCardMaxLines,
+		}),
+		bot.WithForwardCard(flagForwardCard),
+		bot.WithStageTimeouts(bot.StageTimeouts{
+			Fetch:    flagTimeoutFetch,
+			Download: flagTimeoutDownload,
+			Upload:   flagTimeoutUpload,
+		}),
 	)
 }
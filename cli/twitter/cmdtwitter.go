@@ -3,19 +3,26 @@ package twitter
 import (
 	"fmt"
 
+	"github.com/nktknshn/go-twitter-download-bot/bot"
 	"github.com/nktknshn/go-twitter-download-bot/twitter"
 	"github.com/spf13/cobra"
 )
 
 var (
-	flagSaveData bool
+	flagSaveData         bool
+	flagDownloadFolder   string = "."
+	flagFilenameTemplate string = bot.DefaultFilenameTemplate
 )
 
 func init() {
 	Cmd.AddCommand(cmdGetTokens)
 	Cmd.AddCommand(cmdGetData)
+	Cmd.AddCommand(cmdDownload)
 
 	cmdGetData.PersistentFlags().BoolVarP(&flagSaveData, "save-data", "s", false, "save data to file")
+
+	cmdDownload.Flags().StringVarP(&flagDownloadFolder, "download-folder", "d", flagDownloadFolder, "download folder")
+	cmdDownload.Flags().StringVarP(&flagFilenameTemplate, "filename-template", "t", flagFilenameTemplate, "go template of media file names, slashes make subdirectories. Fields: Author, TweetID, Date, Index, Kind, MediaKey, Resolution, Name, Ext")
 }
 
 var (
@@ -36,6 +43,12 @@ var (
 		Args:  cobra.ExactArgs(1),
 		RunE:  runGetData,
 	}
+	cmdDownload = &cobra.Command{
+		Use:   "download",
+		Short: "download <url>",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDownload,
+	}
 )
 
 func runGetTokens(cmd *cobra.Command, args []string) error {
@@ -62,3 +75,28 @@ func runGetData(cmd *cobra.Command, args []string) error {
 	fmt.Println(td)
 	return nil
 }
+
+func runDownload(cmd *cobra.Command, args []string) error {
+	filenameTemplate, err := bot.ParseFilenameTemplate(flagFilenameTemplate)
+	if err != nil {
+		return err
+	}
+
+	td, err := twitter.NewTwitter().GetTwitterData(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	downloader := bot.NewDownloader(bot.WithFilenameTemplate(filenameTemplate))
+
+	downloads, _, err := downloader.DownloadTweetData(cmd.Context(), td, flagDownloadFolder)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range downloads {
+		fmt.Println(d.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/go-faster/errors"
+)
+
+var ErrNoAudio = errors.New("no audio track")
+
+// ftyp of m4a files
+var m4aFtyp = []byte("M4A \x00\x00\x00\x00M4A mp42isom")
+
+// finds the body of the first box of the type
+func findChild(data []byte, typ string) ([]byte, bool) {
+	var found []byte
+
+	errFound := errors.New("found")
+
+	err := walkBoxes(data, func(t string, body []byte) error {
+		if t == typ {
+			found = body
+			return errFound
+		}
+		return nil
+	})
+
+	return found, err == errFound
+}
+
+// finds the body of the nested boxes like mdia, minf, stbl
+func findPath(data []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		var ok bool
+		if data, ok = findChild(data, typ); !ok {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// the audio track data needed to copy its samples
+type audioTrack struct {
+	// copy of the trak box with the header
+	trak []byte
+	// chunk offsets in trak, stco or co64 entries
+	offsets []byte
+	width   int
+	// length of each chunk
+	chunkSizes []int64
+}
+
+func (a *audioTrack) chunkCount() int {
+	return len(a.offsets) / a.width
+}
+
+func (a *audioTrack) offset(i int) int64 {
+	if a.width == 4 {
+		return int64(binary.BigEndian.Uint32(a.offsets[i*4:]))
+	}
+	return int64(binary.BigEndian.Uint64(a.offsets[i*8:]))
+}
+
+func (a *audioTrack) setOffset(i int, offset int64) error {
+	if a.width == 4 {
+		if offset > math.MaxUint32 {
+			return ErrOffsetOverflow
+		}
+		binary.BigEndian.PutUint32(a.offsets[i*4:], uint32(offset))
+		return nil
+	}
+	binary.BigEndian.PutUint64(a.offsets[i*8:], uint64(offset))
+	return nil
+}
+
+// finds the aac track in the moov body and computes the sizes of its chunks
+func findAudioTrack(moov []byte) (*audioTrack, error) {
+	var trak []byte
+
+	err := walkBoxes(moov, func(typ string, body []byte) error {
+		if typ != "trak" || trak != nil {
+			return nil
+		}
+
+		hdlr, ok := findPath(body, "mdia", "hdlr")
+		if !ok {
+			return nil
+		}
+
+		if handler, err := parseHdlr(hdlr); err == nil && handler == TrackAudio {
+			trak = body
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if trak == nil {
+		return nil, ErrNoAudio
+	}
+
+	// a copy with the header, the offsets are patched in it
+	a := &audioTrack{trak: mkBox("trak", trak)}
+
+	stbl, ok := findPath(a.trak[8:], "mdia", "minf", "stbl")
+	if !ok {
+		return nil, errors.Wrap(ErrInvalid, "no stbl")
+	}
+
+	if stsd, ok := findChild(stbl, "stsd"); !ok || len(stsd) < 16 || string(stsd[12:16]) != "mp4a" {
+		return nil, errors.Wrap(ErrNoAudio, "not aac")
+	}
+
+	var offsets []byte
+
+	if stco, ok := findChild(stbl, "stco"); ok {
+		offsets, a.width = stco, 4
+	} else if co64, ok := findChild(stbl, "co64"); ok {
+		offsets, a.width = co64, 8
+	} else {
+		return nil, errors.Wrap(ErrInvalid, "no chunk offsets")
+	}
+
+	if len(offsets) < 8 {
+		return nil, errors.Wrap(ErrInvalid, "truncated chunk offsets")
+	}
+
+	count := int(binary.BigEndian.Uint32(offsets[4:8]))
+	if len(offsets)-8 < count*a.width {
+		return nil, errors.Wrap(ErrInvalid, "truncated chunk offsets")
+	}
+
+	a.offsets = offsets[8 : 8+count*a.width]
+
+	sizes, err := sampleSizes(stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.chunkSizes, err = chunkSizes(stbl, count, sizes); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// the sample size table. A constant size has no table and its count is
+// not trusted for allocations
+type sampleTable struct {
+	// zero if the sizes are in the table
+	size  int64
+	count int
+	table []byte
+}
+
+// sum of the sizes of n samples starting from i
+func (t sampleTable) sum(i, n int) int64 {
+	if t.size != 0 {
+		return int64(n) * t.size
+	}
+
+	var sum int64
+	for j := i; j < i+n; j++ {
+		sum += int64(binary.BigEndian.Uint32(t.table[j*4:]))
+	}
+	return sum
+}
+
+func sampleSizes(stbl []byte) (sampleTable, error) {
+	stsz, ok := findChild(stbl, "stsz")
+	if !ok || len(stsz) < 12 {
+		return sampleTable{}, errors.Wrap(ErrInvalid, "no stsz")
+	}
+
+	t := sampleTable{
+		size:  int64(binary.BigEndian.Uint32(stsz[4:8])),
+		count: int(binary.BigEndian.Uint32(stsz[8:12])),
+		table: stsz[12:],
+	}
+
+	if t.size == 0 && len(t.table) < t.count*4 {
+		return sampleTable{}, errors.Wrap(ErrInvalid, "truncated stsz")
+	}
+
+	return t, nil
+}
+
+// sums the samples of each chunk using the sample to chunk table
+func chunkSizes(stbl []byte, chunks int, samples sampleTable) ([]int64, error) {
+	stsc, ok := findChild(stbl, "stsc")
+	if !ok || len(stsc) < 8 {
+		return nil, errors.Wrap(ErrInvalid, "no stsc")
+	}
+
+	count := int(binary.BigEndian.Uint32(stsc[4:8]))
+	entries := stsc[8:]
+
+	if len(entries) < count*12 {
+		return nil, errors.Wrap(ErrInvalid, "truncated stsc")
+	}
+
+	sizes := make([]int64, chunks)
+	sample := 0
+
+	for e := 0; e < count; e++ {
+		first := int(binary.BigEndian.Uint32(entries[e*12:])) - 1
+		perChunk := int(binary.BigEndian.Uint32(entries[e*12+4:]))
+		last := chunks
+
+		if e+1 < count {
+			last = int(binary.BigEndian.Uint32(entries[(e+1)*12:])) - 1
+		}
+
+		if first < 0 || last > chunks || first > last {
+			return nil, errors.Wrap(ErrInvalid, "bad stsc")
+		}
+
+		for c := first; c < last; c++ {
+			if perChunk > samples.count-sample {
+				return nil, errors.Wrap(ErrInvalid, "stsc has more samples than stsz")
+			}
+			sizes[c] = samples.sum(sample, perChunk)
+			sample += perChunk
+		}
+	}
+
+	return sizes, nil
+}
+
+// ExtractAudio writes the aac track of the mp4 as an m4a file with the moov
+// first. The samples are copied without re-encoding
+func ExtractAudio(w io.Writer, r io.ReaderAt, size int64) error {
+	boxes, err := readBoxes(r, size)
+	if err != nil {
+		return err
+	}
+
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return errors.Wrap(ErrInvalid, "no moov")
+	}
+
+	data, err := readMoov(r, moov)
+	if err != nil {
+		return err
+	}
+
+	body := data[moov.headerSize:]
+
+	mvhd, ok := findChild(body, "mvhd")
+	if !ok {
+		return errors.Wrap(ErrInvalid, "no mvhd")
+	}
+
+	a, err := findAudioTrack(body)
+	if err != nil {
+		return err
+	}
+
+	var mdatSize int64
+	for _, s := range a.chunkSizes {
+		mdatSize += s
+	}
+
+	mdatHeader := binary.BigEndian.AppendUint32(nil, uint32(8+mdatSize))
+	mdatHeader = append(mdatHeader, "mdat"...)
+
+	if 8+mdatSize > math.MaxUint32 {
+		mdatHeader = append(binary.BigEndian.AppendUint32(nil, 1), "mdat"...)
+		mdatHeader = binary.BigEndian.AppendUint64(mdatHeader, uint64(16+mdatSize))
+	}
+
+	ftyp := mkBox("ftyp", m4aFtyp)
+	moovSize := 8 + 8 + len(mvhd) + len(a.trak)
+
+	// the chunks follow each other in the new mdat
+	offset := int64(len(ftyp)+moovSize) + int64(len(mdatHeader))
+	sources := make([]int64, a.chunkCount())
+
+	for i := range sources {
+		sources[i] = a.offset(i)
+
+		if sources[i] < 0 || sources[i]+a.chunkSizes[i] > size {
+			return errors.Wrap(ErrInvalid, "chunk out of file")
+		}
+
+		if err := a.setOffset(i, offset); err != nil {
+			return err
+		}
+
+		offset += a.chunkSizes[i]
+	}
+
+	var out bytes.Buffer
+
+	out.Write(ftyp)
+	out.Write(mkBox("moov", mkBox("mvhd", mvhd), a.trak))
+	out.Write(mdatHeader)
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return errors.Wrap(err, "write header")
+	}
+
+	for i, src := range sources {
+		if _, err := io.Copy(w, io.NewSectionReader(r, src, a.chunkSizes[i])); err != nil {
+			return errors.Wrap(err, "copy chunk")
+		}
+	}
+
+	return nil
+}
+
+// builds a box from the parts
+func mkBox(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// ExtractAudioFile writes the aac track of the mp4 file to dst
+func ExtractAudioFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+
+	err = ExtractAudio(tmp, f, stat.Size())
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
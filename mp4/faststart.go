@@ -0,0 +1,168 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/go-faster/errors"
+)
+
+// ErrOffsetOverflow is returned if moving the moov makes 32 bit chunk offsets overflow
+var ErrOffsetOverflow = errors.New("chunk offset overflow")
+
+// Faststart writes the mp4 with the moov box moved before the first mdat box.
+// Returns false without writing anything if the moov is already there
+func Faststart(w io.Writer, r io.ReaderAt, size int64) (bool, error) {
+	boxes, err := readBoxes(r, size)
+	if err != nil {
+		return false, err
+	}
+
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return false, errors.Wrap(ErrInvalid, "no moov")
+	}
+
+	mdat, ok := findBox(boxes, "mdat")
+	if !ok || moov.offset < mdat.offset {
+		return false, nil
+	}
+
+	data, err := readMoov(r, moov)
+	if err != nil {
+		return false, err
+	}
+
+	// the boxes from the mdat up to the moov move by the moov size
+	if err := shiftChunkOffsets(data[moov.headerSize:], mdat.offset, moov.offset, moov.size); err != nil {
+		return false, err
+	}
+
+	for _, b := range boxes {
+		if b.offset == mdat.offset {
+			if _, err := w.Write(data); err != nil {
+				return false, errors.Wrap(err, "write moov")
+			}
+		}
+
+		if b.offset == moov.offset {
+			continue
+		}
+
+		if _, err := io.Copy(w, io.NewSectionReader(r, b.offset, b.size)); err != nil {
+			return false, errors.Wrapf(err, "copy box %q", b.typ)
+		}
+	}
+
+	return true, nil
+}
+
+// adds delta to the chunk offsets in [from, to)
+func shiftChunkOffsets(moov []byte, from, to, delta int64) error {
+	var walk func(data []byte) error
+
+	walk = func(data []byte) error {
+		return walkBoxes(data, func(typ string, body []byte) error {
+			switch typ {
+			case "trak", "mdia", "minf", "stbl":
+				return walk(body)
+			case "stco":
+				return shiftOffsets(body, 4, from, to, delta)
+			case "co64":
+				return shiftOffsets(body, 8, from, to, delta)
+			}
+			return nil
+		})
+	}
+
+	return walk(moov)
+}
+
+func shiftOffsets(body []byte, width int, from, to, delta int64) error {
+	_, body, err := fullBox(body)
+	if err != nil {
+		return err
+	}
+
+	if len(body) < 4 {
+		return errors.Wrap(ErrInvalid, "truncated chunk offsets")
+	}
+
+	count := int(binary.BigEndian.Uint32(body[:4]))
+	body = body[4:]
+
+	if len(body) < count*width {
+		return errors.Wrap(ErrInvalid, "truncated chunk offsets")
+	}
+
+	for i := 0; i < count; i++ {
+		entry := body[i*width : (i+1)*width]
+
+		if width == 4 {
+			offset := int64(binary.BigEndian.Uint32(entry))
+			if offset < from || offset >= to {
+				continue
+			}
+			if offset+delta > math.MaxUint32 {
+				return ErrOffsetOverflow
+			}
+			binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			continue
+		}
+
+		offset := int64(binary.BigEndian.Uint64(entry))
+		if offset >= from && offset < to {
+			binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+		}
+	}
+
+	return nil
+}
+
+// FaststartFile rewrites the file with the moov box before the media data.
+// Returns false if the file is already so
+func FaststartFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.Wrap(err, "open")
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "stat")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, errors.Wrap(err, "create temp file")
+	}
+
+	moved, err := Faststart(tmp, f, stat.Size())
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && moved {
+		err = os.Chmod(tmp.Name(), stat.Mode().Perm())
+	}
+
+	if err == nil && moved {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil || !moved {
+		_ = os.Remove(tmp.Name())
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "faststart")
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,375 @@
+// Package mp4 reads the movie and track headers of mp4 files and moves the moov box
+// before the media data so the files can be streamed
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+var ErrInvalid = errors.New("invalid mp4")
+
+// moov boxes larger than this are not read
+const maxMoovSize = 64 << 20
+
+const (
+	TrackVideo = "vide"
+	TrackAudio = "soun"
+)
+
+type Track struct {
+	ID uint32
+	// handler type like TrackVideo or TrackAudio
+	Type     string
+	Duration time.Duration
+	// zero for audio tracks
+	Width  int
+	Height int
+}
+
+type Info struct {
+	Duration time.Duration
+	// of the first video track
+	Width  int
+	Height int
+	Tracks []Track
+	// the moov box is before the media data
+	Faststart bool
+}
+
+func (i *Info) HasTrack(typ string) bool {
+	for _, t := range i.Tracks {
+		if t.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Silent is true for videos without audio like the gifs of X
+func (i *Info) Silent() bool {
+	return i.HasTrack(TrackVideo) && !i.HasTrack(TrackAudio)
+}
+
+// top level box
+type box struct {
+	typ    string
+	offset int64
+	// including the header
+	size       int64
+	headerSize int64
+}
+
+// reads the top level boxes
+func readBoxes(r io.ReaderAt, fileSize int64) ([]box, error) {
+	var boxes []box
+	var header [16]byte
+
+	for offset := int64(0); offset < fileSize; {
+		if fileSize-offset < 8 {
+			return nil, errors.Wrap(ErrInvalid, "truncated box header")
+		}
+
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, errors.Wrap(err, "read box header")
+		}
+
+		b := box{
+			typ:        string(header[4:8]),
+			offset:     offset,
+			size:       int64(binary.BigEndian.Uint32(header[:4])),
+			headerSize: 8,
+		}
+
+		switch b.size {
+		case 0:
+			// to the end of the file
+			b.size = fileSize - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, errors.Wrap(err, "read box size")
+			}
+			b.size = int64(binary.BigEndian.Uint64(header[8:16]))
+			b.headerSize = 16
+		}
+
+		if b.size < b.headerSize || offset+b.size > fileSize {
+			return nil, errors.Wrapf(ErrInvalid, "bad size of box %q", b.typ)
+		}
+
+		boxes = append(boxes, b)
+		offset += b.size
+	}
+
+	return boxes, nil
+}
+
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// reads the whole moov box
+func readMoov(r io.ReaderAt, moov box) ([]byte, error) {
+	if moov.size > maxMoovSize {
+		return nil, errors.Wrap(ErrInvalid, "moov is too large")
+	}
+
+	data := make([]byte, moov.size)
+
+	if _, err := r.ReadAt(data, moov.offset); err != nil {
+		return nil, errors.Wrap(err, "read moov")
+	}
+
+	return data, nil
+}
+
+// calls fn with the type and the body of each box in data
+func walkBoxes(data []byte, fn func(typ string, body []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.Wrap(ErrInvalid, "truncated box")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errors.Wrap(ErrInvalid, "truncated box")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(data)) {
+			return errors.Wrapf(ErrInvalid, "bad size of box %q", typ)
+		}
+
+		if err := fn(typ, data[headerSize:size]); err != nil {
+			return err
+		}
+
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// parses the version and flags and returns the version and the rest of the box
+func fullBox(body []byte) (byte, []byte, error) {
+	if len(body) < 4 {
+		return 0, nil, errors.Wrap(ErrInvalid, "truncated full box")
+	}
+	return body[0], body[4:], nil
+}
+
+// returns the timescale and the duration
+func parseMvhd(body []byte) (uint32, uint64, error) {
+	version, body, err := fullBox(body)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if version == 1 {
+		if len(body) < 28 {
+			return 0, 0, errors.Wrap(ErrInvalid, "truncated mvhd")
+		}
+		return binary.BigEndian.Uint32(body[16:20]), binary.BigEndian.Uint64(body[20:28]), nil
+	}
+
+	if len(body) < 16 {
+		return 0, 0, errors.Wrap(ErrInvalid, "truncated mvhd")
+	}
+
+	return binary.BigEndian.Uint32(body[8:12]), uint64(binary.BigEndian.Uint32(body[12:16])), nil
+}
+
+// returns the track with the id, the duration in the movie timescale and the size
+func parseTkhd(body []byte) (Track, uint64, error) {
+	var t Track
+
+	version, body, err := fullBox(body)
+	if err != nil {
+		return t, 0, err
+	}
+
+	var duration uint64
+
+	if version == 1 {
+		if len(body) < 32 {
+			return t, 0, errors.Wrap(ErrInvalid, "truncated tkhd")
+		}
+		t.ID = binary.BigEndian.Uint32(body[16:20])
+		duration = binary.BigEndian.Uint64(body[24:32])
+		body = body[32:]
+	} else {
+		if len(body) < 20 {
+			return t, 0, errors.Wrap(ErrInvalid, "truncated tkhd")
+		}
+		t.ID = binary.BigEndian.Uint32(body[8:12])
+		duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+		body = body[20:]
+	}
+
+	// reserved, layer, alternate group, volume, reserved, matrix
+	const skip = 8 + 2 + 2 + 2 + 2 + 36
+
+	if len(body) < skip+8 {
+		return t, 0, errors.Wrap(ErrInvalid, "truncated tkhd")
+	}
+
+	// 16.16 fixed point
+	t.Width = int(binary.BigEndian.Uint32(body[skip:skip+4]) >> 16)
+	t.Height = int(binary.BigEndian.Uint32(body[skip+4:skip+8]) >> 16)
+
+	return t, duration, nil
+}
+
+func parseHdlr(body []byte) (string, error) {
+	_, body, err := fullBox(body)
+	if err != nil {
+		return "", err
+	}
+
+	if len(body) < 8 {
+		return "", errors.Wrap(ErrInvalid, "truncated hdlr")
+	}
+
+	return string(body[4:8]), nil
+}
+
+func scaleDuration(duration uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	ts := uint64(timescale)
+	// split to not overflow on long tracks
+	return time.Duration(duration/ts)*time.Second + time.Duration(duration%ts*uint64(time.Second)/ts)
+}
+
+func parseTrak(body []byte, timescale uint32) (Track, error) {
+	var t Track
+	var duration uint64
+
+	err := walkBoxes(body, func(typ string, body []byte) (err error) {
+		switch typ {
+		case "tkhd":
+			var header Track
+			header, duration, err = parseTkhd(body)
+			t.ID, t.Width, t.Height = header.ID, header.Width, header.Height
+		case "mdia":
+			return walkBoxes(body, func(typ string, body []byte) (err error) {
+				if typ == "hdlr" {
+					t.Type, err = parseHdlr(body)
+				}
+				return err
+			})
+		}
+		return err
+	})
+
+	t.Duration = scaleDuration(duration, timescale)
+
+	if t.Type != TrackVideo {
+		t.Width, t.Height = 0, 0
+	}
+
+	return t, err
+}
+
+func parseMoov(moov []byte, info *Info) error {
+	var timescale uint32
+	var duration uint64
+	var traks [][]byte
+
+	err := walkBoxes(moov, func(typ string, body []byte) (err error) {
+		switch typ {
+		case "mvhd":
+			timescale, duration, err = parseMvhd(body)
+		case "trak":
+			traks = append(traks, body)
+		}
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	info.Duration = scaleDuration(duration, timescale)
+
+	for _, body := range traks {
+		t, err := parseTrak(body, timescale)
+		if err != nil {
+			return err
+		}
+
+		if t.Type == TrackVideo && info.Width == 0 {
+			info.Width, info.Height = t.Width, t.Height
+		}
+
+		info.Tracks = append(info.Tracks, t)
+	}
+
+	return nil
+}
+
+// Read parses the headers of the mp4
+func Read(r io.ReaderAt, size int64) (*Info, error) {
+	boxes, err := readBoxes(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return nil, errors.Wrap(ErrInvalid, "no moov")
+	}
+
+	data, err := readMoov(r, moov)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Faststart: true}
+
+	if mdat, ok := findBox(boxes, "mdat"); ok && mdat.offset < moov.offset {
+		info.Faststart = false
+	}
+
+	if err := parseMoov(data[moov.headerSize:], info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ReadFile parses the headers of the mp4 file
+func ReadFile(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "stat")
+	}
+
+	return Read(f, stat.Size())
+}
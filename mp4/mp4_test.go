@@ -0,0 +1,200 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func u32(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func mvhd(timescale, duration uint32) []byte {
+	// version and flags, creation, modification
+	return mkBox("mvhd", u32(0, 0, 0, timescale, duration), make([]byte, 80))
+}
+
+func tkhd(id, duration uint32, width, height uint32) []byte {
+	return mkBox("tkhd",
+		u32(0, 0, 0, id, 0, duration),
+		make([]byte, 8+2+2+2+2+36),
+		u32(width<<16, height<<16),
+	)
+}
+
+func hdlr(typ string) []byte {
+	return mkBox("hdlr", u32(0, 0), []byte(typ), make([]byte, 13))
+}
+
+func trak(id uint32, typ string, width, height uint32, offset uint32) []byte {
+	return mkBox("trak",
+		tkhd(id, 3000, width, height),
+		mkBox("mdia", hdlr(typ), mkBox("minf", mkBox("stbl", mkBox("stco", u32(0, 1, offset))))),
+	)
+}
+
+// ftyp, mdat with the media and moov at the end
+func testFile(withAudio bool) ([]byte, []byte) {
+	ftyp := mkBox("ftyp", []byte("isom"), u32(512), []byte("isommp41"))
+	media := []byte("video frames and audio samples")
+	mdat := mkBox("mdat", media)
+	offset := uint32(len(ftyp) + 8)
+
+	traks := [][]byte{mvhd(1000, 3000), trak(1, TrackVideo, 1280, 720, offset)}
+	if withAudio {
+		traks = append(traks, trak(2, TrackAudio, 0, 0, offset+5))
+	}
+
+	return bytes.Join([][]byte{ftyp, mdat, mkBox("moov", traks...)}, nil), media
+}
+
+func TestRead(t *testing.T) {
+	data, _ := testFile(true)
+
+	info, err := Read(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	require.Equal(t, 3*time.Second, info.Duration)
+	require.Equal(t, 1280, info.Width)
+	require.Equal(t, 720, info.Height)
+	require.False(t, info.Faststart)
+	require.False(t, info.Silent())
+	require.Equal(t, []Track{
+		{ID: 1, Type: TrackVideo, Duration: 3 * time.Second, Width: 1280, Height: 720},
+		{ID: 2, Type: TrackAudio, Duration: 3 * time.Second},
+	}, info.Tracks)
+
+	data, _ = testFile(false)
+
+	info, err = Read(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.True(t, info.Silent())
+
+	_, err = Read(bytes.NewReader(data[:len(data)-4]), int64(len(data)-4))
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestFaststart(t *testing.T) {
+	data, media := testFile(true)
+
+	var out bytes.Buffer
+
+	moved, err := Faststart(&out, bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.True(t, moved)
+	require.Equal(t, len(data), out.Len())
+
+	result := out.Bytes()
+
+	info, err := Read(bytes.NewReader(result), int64(len(result)))
+	require.NoError(t, err)
+	require.True(t, info.Faststart)
+	require.Len(t, info.Tracks, 2)
+
+	// the chunk offsets point to the same media
+	var offsets []uint32
+
+	var walk func(data []byte) error
+	walk = func(data []byte) error {
+		return walkBoxes(data, func(typ string, body []byte) error {
+			switch typ {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				return walk(body)
+			case "stco":
+				offsets = append(offsets, binary.BigEndian.Uint32(body[8:12]))
+			}
+			return nil
+		})
+	}
+
+	require.NoError(t, walk(result))
+	require.Len(t, offsets, 2)
+	require.Equal(t, media[:5], result[offsets[0]:offsets[0]+5])
+	require.Equal(t, media[5:10], result[offsets[1]:offsets[1]+5])
+
+	// already faststart
+	moved, err = Faststart(&out, bytes.NewReader(result), int64(len(result)))
+	require.NoError(t, err)
+	require.False(t, moved)
+}
+
+func TestExtractAudio(t *testing.T) {
+	ftyp := mkBox("ftyp", []byte("isom"), u32(512), []byte("isommp41"))
+	media := []byte("VVVVVaaaVVVbbbb")
+	offset := uint32(len(ftyp) + 8)
+
+	// two chunks of two samples
+	stbl := mkBox("stbl",
+		mkBox("stsd", u32(0, 1), mkBox("mp4a", make([]byte, 28))),
+		mkBox("stsc", u32(0, 1, 1, 2, 1)),
+		mkBox("stsz", u32(0, 0, 4, 1, 2, 3, 1)),
+		mkBox("stco", u32(0, 2, offset+5, offset+11)),
+	)
+	audio := mkBox("trak", tkhd(2, 3000, 0, 0), mkBox("mdia", hdlr(TrackAudio), mkBox("minf", stbl)))
+
+	data := bytes.Join([][]byte{
+		ftyp,
+		mkBox("mdat", media),
+		mkBox("moov", mvhd(1000, 3000), trak(1, TrackVideo, 1280, 720, offset), audio),
+	}, nil)
+
+	var out bytes.Buffer
+
+	require.NoError(t, ExtractAudio(&out, bytes.NewReader(data), int64(len(data))))
+
+	result := out.Bytes()
+
+	info, err := Read(bytes.NewReader(result), int64(len(result)))
+	require.NoError(t, err)
+	require.True(t, info.Faststart)
+	require.Equal(t, 3*time.Second, info.Duration)
+	require.Equal(t, []Track{{ID: 2, Type: TrackAudio, Duration: 3 * time.Second}}, info.Tracks)
+	require.Equal(t, []byte("aaabbbb"), result[len(result)-7:])
+
+	stco, ok := findPath(result, "moov", "trak", "mdia", "minf", "stbl", "stco")
+	require.True(t, ok)
+
+	first, second := binary.BigEndian.Uint32(stco[8:]), binary.BigEndian.Uint32(stco[12:])
+	require.Equal(t, []byte("aaa"), result[first:first+3])
+	require.Equal(t, []byte("bbbb"), result[second:second+4])
+
+	// the video only file
+	data, _ = testFile(false)
+	err = ExtractAudio(&out, bytes.NewReader(data), int64(len(data)))
+	require.ErrorIs(t, err, ErrNoAudio)
+}
+
+func TestChunkSizes(t *testing.T) {
+	// constant size of 4 with the max count, two chunks of three samples
+	stbl := bytes.Join([][]byte{
+		mkBox("stsz", u32(0, 4, 0xffffffff)),
+		mkBox("stsc", u32(0, 1, 1, 3, 1)),
+	}, nil)
+
+	samples, err := sampleSizes(stbl)
+	require.NoError(t, err)
+
+	sizes, err := chunkSizes(stbl, 2, samples)
+	require.NoError(t, err)
+	require.Equal(t, []int64{12, 12}, sizes)
+
+	// more samples than the table has
+	stbl = bytes.Join([][]byte{
+		mkBox("stsz", u32(0, 0, 2, 1, 2)),
+		mkBox("stsc", u32(0, 1, 1, 3, 1)),
+	}, nil)
+
+	samples, err = sampleSizes(stbl)
+	require.NoError(t, err)
+
+	_, err = chunkSizes(stbl, 1, samples)
+	require.ErrorIs(t, err, ErrInvalid)
+}
@@ -0,0 +1,246 @@
+package twitter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// ErrUnavailable is returned while the circuit breaker is open
+var ErrUnavailable = errors.New("twitter is unavailable")
+
+// StatusError is an error response of X
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func newStatusError(resp *resty.Response) *StatusError {
+	return &StatusError{Code: resp.StatusCode(), Status: resp.Status()}
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// network errors, rate limiting and server errors mean X is in trouble. Client errors
+// like deleted or protected tweets are about the request and don't count
+func isServiceFailure(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+type BreakerState int
+
+const (
+	// requests go through
+	BreakerClosed BreakerState = iota
+	// requests are rejected with ErrUnavailable
+	BreakerOpen
+	// a limited number of probe requests go through
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerSettings struct {
+	// consecutive failures to open the breaker
+	FailureThreshold int
+	// time to stay open before letting probes through
+	OpenTimeout time.Duration
+	// number of probes allowed in half-open state. All of them have to succeed to close the breaker
+	HalfOpenProbes int
+}
+
+func DefaultBreakerSettings() BreakerSettings {
+	return BreakerSettings{
+		FailureThreshold: 5,
+		OpenTimeout:      time.Minute,
+		HalfOpenProbes:   1,
+	}
+}
+
+// CircuitBreaker stops calling X after consecutive failures
+type CircuitBreaker struct {
+	logger   *zap.Logger
+	settings BreakerSettings
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+
+	onStateChange func(from, to BreakerState)
+
+	nowFunc func() time.Time
+}
+
+func NewCircuitBreaker(settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 1
+	}
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+	return &CircuitBreaker{
+		logger:   logger.Named("breaker"),
+		settings: settings,
+		state:    BreakerClosed,
+		nowFunc:  time.Now,
+	}
+}
+
+// OnStateChange sets the callback called on every state transition. The callback must not block
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to BreakerState)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.onStateChange = fn
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.updateLocked()
+	return cb.state
+}
+
+// Available returns false if requests are going to be rejected
+func (cb *CircuitBreaker) Available() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.updateLocked()
+
+	switch cb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return cb.probes < cb.settings.HalfOpenProbes
+	}
+
+	return true
+}
+
+// moves open breaker to half-open after the timeout
+func (cb *CircuitBreaker) updateLocked() {
+	if cb.state == BreakerOpen && cb.nowFunc().Sub(cb.openedAt) >= cb.settings.OpenTimeout {
+		cb.setStateLocked(BreakerHalfOpen)
+	}
+}
+
+func (cb *CircuitBreaker) setStateLocked(state BreakerState) {
+	if cb.state == state {
+		return
+	}
+
+	from := cb.state
+	cb.state = state
+	cb.failures = 0
+	cb.probes = 0
+	cb.successes = 0
+
+	if state == BreakerOpen {
+		cb.openedAt = cb.nowFunc()
+	}
+
+	cb.logger.Info("state changed", zap.Stringer("from", from), zap.Stringer("to", state))
+
+	if cb.onStateChange != nil {
+		cb.onStateChange(from, state)
+	}
+}
+
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.updateLocked()
+
+	switch cb.state {
+	case BreakerOpen:
+		return ErrUnavailable
+	case BreakerHalfOpen:
+		if cb.probes >= cb.settings.HalfOpenProbes {
+			return ErrUnavailable
+		}
+		cb.probes++
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerClosed:
+		if success {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.settings.FailureThreshold {
+			cb.setStateLocked(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if !success {
+			cb.setStateLocked(BreakerOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.settings.HalfOpenProbes {
+			cb.setStateLocked(BreakerClosed)
+		}
+	}
+}
+
+// Execute calls fn if the breaker allows it and records the result. Only network errors,
+// rate limiting and server errors are counted as failures, cancelled requests are not counted
+func (cb *CircuitBreaker) Execute(ctx context.Context, fn func() error) error {
+	if err := cb.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+
+	if err != nil && ctx.Err() != nil {
+		cb.release()
+		return err
+	}
+
+	cb.record(err == nil || !isServiceFailure(err))
+
+	return err
+}
+
+// gives back a probe slot without recording a result
+func (cb *CircuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
@@ -0,0 +1,155 @@
+package twitter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		// advances the clock before the step
+		advance time.Duration
+		// "ok", "fail" and "missing" execute a request, "probe" takes a probe slot without finishing it
+		do string
+		// the error the step is expected to return
+		err   error
+		state BreakerState
+	}
+
+	settings := BreakerSettings{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenProbes: 2}
+	failure := &StatusError{Code: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+	notFound := &StatusError{Code: http.StatusNotFound, Status: "404 Not Found"}
+
+	cases := []struct {
+		name        string
+		steps       []step
+		transitions [][2]BreakerState
+	}{
+		{
+			name: "success resets failures",
+			steps: []step{
+				{do: "fail", err: failure, state: BreakerClosed},
+				{do: "ok", state: BreakerClosed},
+				{do: "fail", err: failure, state: BreakerClosed},
+			},
+		},
+		{
+			name: "client errors are not failures",
+			steps: []step{
+				{do: "missing", err: notFound},
+				{do: "missing", err: notFound},
+				{do: "fail", err: failure},
+				{do: "missing", err: notFound},
+				{do: "fail", err: failure, state: BreakerClosed},
+			},
+		},
+		{
+			name: "open, half-open, closed",
+			steps: []step{
+				{do: "fail", err: failure, state: BreakerClosed},
+				{do: "fail", err: failure, state: BreakerOpen},
+				{do: "ok", err: ErrUnavailable, state: BreakerOpen},
+				{advance: 59 * time.Second, do: "ok", err: ErrUnavailable, state: BreakerOpen},
+				{advance: time.Second, do: "ok", state: BreakerHalfOpen},
+				{do: "ok", state: BreakerClosed},
+				{do: "ok", state: BreakerClosed},
+			},
+			transitions: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+				{BreakerHalfOpen, BreakerClosed},
+			},
+		},
+		{
+			name: "failed probe opens again",
+			steps: []step{
+				{do: "fail", err: failure},
+				{do: "fail", err: failure, state: BreakerOpen},
+				{advance: time.Minute, do: "ok", state: BreakerHalfOpen},
+				{do: "fail", err: failure, state: BreakerOpen},
+				{advance: 30 * time.Second, do: "ok", err: ErrUnavailable, state: BreakerOpen},
+			},
+			transitions: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+				{BreakerHalfOpen, BreakerOpen},
+			},
+		},
+		{
+			name: "probes are limited",
+			steps: []step{
+				{do: "fail", err: failure},
+				{do: "fail", err: failure, state: BreakerOpen},
+				{advance: time.Minute, do: "probe", state: BreakerHalfOpen},
+				{do: "probe", state: BreakerHalfOpen},
+				{do: "probe", err: ErrUnavailable, state: BreakerHalfOpen},
+				{do: "ok", err: ErrUnavailable, state: BreakerHalfOpen},
+			},
+			transitions: [][2]BreakerState{
+				{BreakerClosed, BreakerOpen},
+				{BreakerOpen, BreakerHalfOpen},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+
+			cb := NewCircuitBreaker(settings)
+			cb.nowFunc = func() time.Time { return now }
+
+			var transitions [][2]BreakerState
+			cb.OnStateChange(func(from, to BreakerState) {
+				transitions = append(transitions, [2]BreakerState{from, to})
+			})
+
+			for i, s := range c.steps {
+				now = now.Add(s.advance)
+
+				var err error
+				switch s.do {
+				case "ok":
+					err = cb.Execute(context.Background(), func() error { return nil })
+				case "fail":
+					err = cb.Execute(context.Background(), func() error { return failure })
+				case "missing":
+					err = cb.Execute(context.Background(), func() error { return errors.Wrap(notFound, "get") })
+				case "probe":
+					err = cb.allow()
+				}
+
+				// errors.Is(err, nil) holds only for a nil err
+				require.ErrorIs(t, err, s.err, "step %d", i)
+				require.Equal(t, s.state, cb.State(), "step %d", i)
+			}
+
+			require.Equal(t, c.transitions, transitions)
+		})
+	}
+}
+
+func TestIsServiceFailure(t *testing.T) {
+	require.True(t, isServiceFailure(&StatusError{Code: http.StatusTooManyRequests}))
+	require.True(t, isServiceFailure(errors.Wrap(&StatusError{Code: http.StatusBadGateway}, "get")))
+	require.True(t, isServiceFailure(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	require.False(t, isServiceFailure(&StatusError{Code: http.StatusForbidden}))
+	require.False(t, isServiceFailure(errors.New("failed to find guest token")))
+}
+
+func TestCircuitBreakerCanceled(t *testing.T) {
+	cb := NewCircuitBreaker(BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := cb.Execute(ctx, func() error { return ctx.Err() })
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, BreakerClosed, cb.State())
+}
@@ -0,0 +1,305 @@
+package twitter
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheOptions struct {
+	// how long the tweet data is kept
+	TTL time.Duration
+	// max number of tweets kept in memory
+	Size int
+	// optional directory to persist the cache to
+	Dir string
+	// max number of tweets kept in Dir, the oldest are removed first. Zero means no limit
+	DiskSize int
+}
+
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		TTL:      time.Hour,
+		Size:     1000,
+		DiskSize: 10000,
+	}
+}
+
+// a shared fetch outlives the canceled callers, so it needs its own limit
+const cacheFetchTimeout = time.Minute
+
+type cacheEntry struct {
+	ID     string    `json:"id"`
+	Data   TweetData `json:"data"`
+	Stored time.Time `json:"stored"`
+}
+
+// Cache is a TTL/LRU cache of tweet data keyed by tweet id. Entries evicted from
+// memory stay on disk until they expire. Concurrent fetches of the same tweet are coalesced into one
+type Cache struct {
+	logger  *zap.Logger
+	options CacheOptions
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	// store times of the files in Dir
+	disk map[string]time.Time
+
+	group singleflight.Group
+
+	nowFunc func() time.Time
+}
+
+func NewCache(options CacheOptions) (*Cache, error) {
+	c := &Cache{
+		logger:  logger.Named("cache"),
+		options: options,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		disk:    make(map[string]time.Time),
+		nowFunc: time.Now,
+	}
+
+	if options.Dir == "" {
+		return c, nil
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create cache dir")
+	}
+
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cache dir")
+	}
+
+	// the modification time is the store time
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			c.disk[id] = info.ModTime()
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Cache) expired(e *cacheEntry) bool {
+	return c.nowFunc().Sub(e.Stored) > c.options.TTL
+}
+
+// Get returns the tweet data from memory or from disk
+func (c *Cache) Get(id string) (*TweetData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[id]; ok {
+		e := el.Value.(*cacheEntry)
+		if !c.expired(e) {
+			c.ll.MoveToFront(el)
+			return &e.Data, true
+		}
+		c.removeLocked(el)
+		c.removeFileLocked(id)
+	}
+
+	e, ok := c.load(id)
+
+	if !ok {
+		return nil, false
+	}
+
+	c.addLocked(e)
+
+	return &e.Data, true
+}
+
+func (c *Cache) Set(id string, td *TweetData) {
+	e := &cacheEntry{ID: id, Data: *td, Stored: c.nowFunc()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[id]; ok {
+		c.removeLocked(el)
+	}
+
+	c.addLocked(e)
+	c.save(e)
+}
+
+func (c *Cache) addLocked(e *cacheEntry) {
+	c.items[e.ID] = c.ll.PushFront(e)
+
+	for c.options.Size > 0 && c.ll.Len() > c.options.Size {
+		c.removeLocked(c.ll.Back())
+	}
+}
+
+// removes from memory only, the file is kept until it expires
+func (c *Cache) removeLocked(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).ID)
+}
+
+func (c *Cache) removeFileLocked(id string) {
+	if c.options.Dir == "" {
+		return
+	}
+
+	delete(c.disk, id)
+
+	if err := os.Remove(c.filePath(id)); err != nil && !os.IsNotExist(err) {
+		c.logger.Error("failed to remove cache file", zap.String("id", id), zap.Error(err))
+	}
+}
+
+// removes the oldest files over the disk size
+func (c *Cache) trimDiskLocked() {
+	for c.options.DiskSize > 0 && len(c.disk) > c.options.DiskSize {
+		var (
+			oldest   string
+			oldestAt time.Time
+		)
+
+		for id, stored := range c.disk {
+			if oldest == "" || stored.Before(oldestAt) {
+				oldest, oldestAt = id, stored
+			}
+		}
+
+		c.removeFileLocked(oldest)
+	}
+}
+
+// Fetch returns cached data or calls fetch. Concurrent calls with the same id share one fetch.
+// The shared fetch is not canceled with ctx, a canceled caller just stops waiting for it
+func (c *Cache) Fetch(ctx context.Context, id string, fetch func(ctx context.Context) (*TweetData, error)) (*TweetData, error) {
+	if td, ok := c.Get(id); ok {
+		c.logger.Debug("cache hit", zap.String("id", id))
+		return td, nil
+	}
+
+	ch := c.group.DoChan(id, func() (interface{}, error) {
+		// might have been set while waiting
+		if td, ok := c.Get(id); ok {
+			return td, nil
+		}
+
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheFetchTimeout)
+		defer cancel()
+
+		td, err := fetch(fetchCtx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !td.IsEmpty() {
+			c.Set(id, td)
+		}
+
+		return td, nil
+	})
+
+	var res singleflight.Result
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-ch:
+	}
+
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	if res.Shared {
+		c.logger.Debug("shared fetch", zap.String("id", id))
+	}
+
+	return res.Val.(*TweetData), nil
+}
+
+func (c *Cache) filePath(id string) string {
+	return path.Join(c.options.Dir, id+".json")
+}
+
+func (c *Cache) load(id string) (*cacheEntry, bool) {
+	if c.options.Dir == "" {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.filePath(id))
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Error("failed to read cache file", zap.String("id", id), zap.Error(err))
+		}
+		return nil, false
+	}
+
+	e := &cacheEntry{}
+
+	if err := json.Unmarshal(data, e); err != nil {
+		c.logger.Error("failed to decode cache file", zap.String("id", id), zap.Error(err))
+		return nil, false
+	}
+
+	if e.ID != id {
+		return nil, false
+	}
+
+	if c.expired(e) {
+		c.removeFileLocked(id)
+		return nil, false
+	}
+
+	return e, true
+}
+
+func (c *Cache) save(e *cacheEntry) {
+	if c.options.Dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(e)
+
+	if err != nil {
+		c.logger.Error("failed to encode cache entry", zap.String("id", e.ID), zap.Error(err))
+		return
+	}
+
+	tmp := c.filePath(e.ID) + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		c.logger.Error("failed to write cache file", zap.String("id", e.ID), zap.Error(err))
+		return
+	}
+
+	// the index is rebuilt from the modification times at start
+	if err := os.Chtimes(tmp, e.Stored, e.Stored); err != nil {
+		c.logger.Error("failed to set cache file time", zap.String("id", e.ID), zap.Error(err))
+	}
+
+	if err := os.Rename(tmp, c.filePath(e.ID)); err != nil {
+		c.logger.Error("failed to rename cache file", zap.String("id", e.ID), zap.Error(err))
+		return
+	}
+
+	c.disk[e.ID] = e.Stored
+	c.trimDiskLocked()
+}
@@ -0,0 +1,161 @@
+package twitter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache(t *testing.T, options CacheOptions, now *time.Time) *Cache {
+	c, err := NewCache(options)
+	require.NoError(t, err)
+	c.nowFunc = func() time.Time { return *now }
+	return c
+}
+
+func TestCacheEviction(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	dir := t.TempDir()
+
+	c := newTestCache(t, CacheOptions{TTL: time.Hour, Size: 2, Dir: dir}, &now)
+
+	c.Set("1", &TweetData{FullText: "one"})
+	c.Set("2", &TweetData{FullText: "two"})
+
+	// 1 becomes the most recently used
+	_, ok := c.Get("1")
+	require.True(t, ok)
+
+	c.Set("3", &TweetData{FullText: "three"})
+
+	// evicted from memory only
+	_, ok = c.items["2"]
+	require.False(t, ok)
+	require.FileExists(t, c.filePath("2"))
+
+	td, ok := c.Get("2")
+	require.True(t, ok)
+	require.Equal(t, "two", td.FullText)
+
+	now = now.Add(time.Hour + time.Second)
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, ok := c.Get(id)
+		require.False(t, ok, id)
+		require.NoFileExists(t, c.filePath(id))
+	}
+}
+
+func TestCacheDiskSize(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	options := CacheOptions{TTL: time.Hour, Size: 1, Dir: t.TempDir(), DiskSize: 2}
+
+	c := newTestCache(t, options, &now)
+
+	for _, id := range []string{"1", "2", "3"} {
+		c.Set(id, &TweetData{FullText: id})
+		now = now.Add(time.Second)
+	}
+
+	require.NoFileExists(t, c.filePath("1"))
+	require.FileExists(t, c.filePath("2"))
+	require.FileExists(t, c.filePath("3"))
+
+	// the files found at start count too
+	c = newTestCache(t, options, &now)
+	c.Set("4", &TweetData{FullText: "4"})
+
+	require.NoFileExists(t, c.filePath("2"))
+	require.FileExists(t, c.filePath("3"))
+}
+
+func TestCacheReload(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	options := CacheOptions{TTL: time.Hour, Size: 10, Dir: t.TempDir()}
+
+	c := newTestCache(t, options, &now)
+	c.Set("1", &TweetData{FullText: "one"})
+	c.Set("2", &TweetData{FullText: "two"})
+
+	now = now.Add(30 * time.Minute)
+	c = newTestCache(t, options, &now)
+
+	td, ok := c.Get("1")
+	require.True(t, ok)
+	require.Equal(t, "one", td.FullText)
+
+	// expired on disk
+	now = now.Add(31 * time.Minute)
+	c = newTestCache(t, options, &now)
+
+	_, ok = c.Get("2")
+	require.False(t, ok)
+	require.NoFileExists(t, c.filePath("2"))
+}
+
+func TestCacheFetch(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newTestCache(t, CacheOptions{TTL: time.Hour, Size: 10}, &now)
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	fetch := func(ctx context.Context) (*TweetData, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-unblock
+		// the leader is canceled below, the shared fetch is not
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &TweetData{FullText: "one"}, nil
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := c.Fetch(leaderCtx, "1", fetch)
+		leaderErr <- err
+	}()
+
+	<-started
+
+	var wg sync.WaitGroup
+	results := make([]*TweetData, 5)
+	errs := make([]error, 5)
+
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.Fetch(context.Background(), "1", fetch)
+		}(i)
+	}
+
+	cancelLeader()
+	require.ErrorIs(t, <-leaderErr, context.Canceled)
+
+	// let the waiters join the flight
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	for i := range results {
+		require.NoError(t, errs[i])
+		require.Equal(t, "one", results[i].FullText)
+	}
+
+	require.Equal(t, int32(1), calls.Load())
+
+	td, err := c.Fetch(context.Background(), "1", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "one", td.FullText)
+	require.Equal(t, int32(1), calls.Load())
+}
@@ -3,6 +3,8 @@ package twitter
 import (
 	"bytes"
 	"encoding/json"
+	"strconv"
+	"time"
 )
 
 func JsonDecodeWithNumberString(data string, v any) error {
@@ -14,3 +16,15 @@ func JsonDecodeWithNumberBytes(data []byte, v any) error {
 	d.UseNumber()
 	return d.Decode(&v)
 }
+
+// twitter snowflake epoch in milliseconds
+const snowflakeEpoch = 1288834974657
+
+// SnowflakeTime decodes the creation time from a tweet id
+func SnowflakeTime(id string) (time.Time, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(n>>22 + snowflakeEpoch).UTC(), true
+}
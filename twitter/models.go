@@ -3,6 +3,8 @@ package twitter
 import (
 	"fmt"
 	"regexp"
+	"strconv"
+	"time"
 )
 
 func ParseURLFilename(url string) string {
@@ -25,7 +27,26 @@ func (vd VideoVariant) Filename() string {
 	return ParseURLFilename(vd.VideoURL)
 }
 
+var reVariantResolution = regexp.MustCompile(`/(\d+)x(\d+)/`)
+
+// Resolution is parsed from the url like .../vid/avc1/720x1280/name.mp4
+func (vd VideoVariant) Resolution() (width, height int, ok bool) {
+	m := reVariantResolution.FindStringSubmatch(vd.VideoURL)
+	if m == nil {
+		return 0, 0, false
+	}
+	width, _ = strconv.Atoi(m[1])
+	height, _ = strconv.Atoi(m[2])
+	return width, height, true
+}
+
+// IsMP4 is false for streaming playlists
+func (vd VideoVariant) IsMP4() bool {
+	return vd.ContentType == "" || vd.ContentType == "video/mp4"
+}
+
 type Photo struct {
+	MediaKey      string `json:"media_key"`
 	MediaURLHttps string `json:"media_url_https"`
 }
 
@@ -40,6 +61,18 @@ func (pd Photo) URL() string {
 type Video struct {
 	MediaKey string        `json:"media_key"`
 	Variants VideoVariants `json:"video_variants"`
+	// from original_info, zero if unknown
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// from video_info
+	DurationMillis int `json:"duration_millis,omitempty"`
+	// the poster frame image
+	PosterURL string `json:"poster_url,omitempty"`
+}
+
+// Duration is zero if unknown
+func (v Video) Duration() time.Duration {
+	return time.Duration(v.DurationMillis) * time.Millisecond
 }
 
 type VideoVariants []VideoVariant
@@ -64,6 +97,21 @@ type TweetData struct {
 	Text     string
 	Videos   []Video
 	Photos   []Photo
+	// display name and handle of the author, empty if unknown
+	AuthorName       string
+	AuthorScreenName string
+	// zero if unknown
+	CreatedAt time.Time
+	// the quoted tweet, its media is also in Videos and Photos
+	Quoted *TweetData
+}
+
+// Author returns the handle of the author, the user of the url if unknown
+func (td *TweetData) Author() string {
+	if td.AuthorScreenName != "" {
+		return td.AuthorScreenName
+	}
+	return td.Url.User
 }
 
 func (td *TweetData) NoMedia() bool {
@@ -0,0 +1,268 @@
+package twitter
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/ratelimit"
+	"go.uber.org/zap"
+)
+
+// HostGroup is a group of X hosts sharing the same request budget
+type HostGroup string
+
+const (
+	// tweet page and main.js used to scrape tokens
+	HostGroupToken HostGroup = "token"
+	// api.twitter.com graphql endpoints
+	HostGroupGraphQL HostGroup = "graphql"
+	// pbs.twimg.com and video.twimg.com
+	HostGroupMedia HostGroup = "media"
+)
+
+// HostGroupOf returns the group of the url host. Returns false for hosts that are not limited
+func HostGroupOf(rawurl string) (HostGroup, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", false
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch {
+	case host == "api.twitter.com" || host == "api.x.com":
+		return HostGroupGraphQL, true
+	case host == "twitter.com" || host == "x.com" || host == "mobile.twitter.com" || host == "abs.twimg.com":
+		return HostGroupToken, true
+	case strings.HasSuffix(host, ".twimg.com"):
+		return HostGroupMedia, true
+	}
+
+	return "", false
+}
+
+// RateLimits is a number of requests per minute for each host group. Zero means no limit
+type RateLimits struct {
+	Token   int
+	GraphQL int
+	Media   int
+}
+
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Token:   10,
+		GraphQL: 20,
+		Media:   120,
+	}
+}
+
+type hostLimiter struct {
+	limiter  ratelimit.Limiter
+	interval time.Duration
+	// Take can't be canceled so one goroutine takes the permits and hands them
+	// to the waiters. A permit of a canceled waiter goes to the next one
+	permits   chan struct{}
+	startOnce sync.Once
+	// number of requests waiting for a permit
+	waiting int
+	// set from x-rate-limit-* headers
+	pausedUntil time.Time
+}
+
+// RateLimiter paces outbound requests to X. It is meant to be shared between
+// all the http clients talking to X
+type RateLimiter struct {
+	logger *zap.Logger
+	mu     sync.Mutex
+	hosts  map[HostGroup]*hostLimiter
+
+	nowFunc func() time.Time
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	rl := &RateLimiter{
+		logger:  logger.Named("ratelimit"),
+		hosts:   make(map[HostGroup]*hostLimiter),
+		nowFunc: time.Now,
+	}
+
+	rl.hosts[HostGroupToken] = newHostLimiter(limits.Token)
+	rl.hosts[HostGroupGraphQL] = newHostLimiter(limits.GraphQL)
+	rl.hosts[HostGroupMedia] = newHostLimiter(limits.Media)
+
+	return rl
+}
+
+func newHostLimiter(perMinute int) *hostLimiter {
+	if perMinute <= 0 {
+		return &hostLimiter{}
+	}
+	return &hostLimiter{
+		limiter:  ratelimit.New(perMinute, ratelimit.Per(time.Minute), ratelimit.WithSlack(1)),
+		interval: time.Minute / time.Duration(perMinute),
+		permits:  make(chan struct{}),
+	}
+}
+
+// blocks until a permit is taken. Nil for unlimited hosts
+func (hl *hostLimiter) permit() <-chan struct{} {
+	if hl.limiter == nil {
+		return nil
+	}
+
+	hl.startOnce.Do(func() {
+		go func() {
+			for {
+				hl.limiter.Take()
+				hl.permits <- struct{}{}
+			}
+		}()
+	})
+
+	return hl.permits
+}
+
+type waitNotifyKey struct{}
+
+// WithWaitNotify returns a context that makes the limiter call fn with the estimated
+// wait when a request made with this context is going to be delayed
+func WithWaitNotify(ctx context.Context, fn func(wait time.Duration)) context.Context {
+	return context.WithValue(ctx, waitNotifyKey{}, fn)
+}
+
+func waitNotify(ctx context.Context) func(time.Duration) {
+	fn, _ := ctx.Value(waitNotifyKey{}).(func(time.Duration))
+	return fn
+}
+
+// requests delayed less than this are not reported
+const waitNotifyThreshold = 2 * time.Second
+
+// Estimate returns an estimated delay for a request to the url
+func (rl *RateLimiter) Estimate(rawurl string) time.Duration {
+	group, ok := HostGroupOf(rawurl)
+	if !ok {
+		return 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.estimateLocked(rl.hosts[group])
+}
+
+func (rl *RateLimiter) estimateLocked(hl *hostLimiter) time.Duration {
+	wait := time.Duration(hl.waiting) * hl.interval
+
+	if paused := hl.pausedUntil.Sub(rl.nowFunc()); paused > 0 {
+		wait += paused
+	}
+
+	return wait
+}
+
+// Wait blocks until a request to the url is allowed
+func (rl *RateLimiter) Wait(ctx context.Context, rawurl string) error {
+	group, ok := HostGroupOf(rawurl)
+	if !ok {
+		return nil
+	}
+
+	rl.mu.Lock()
+	hl := rl.hosts[group]
+	estimate := rl.estimateLocked(hl)
+	pause := hl.pausedUntil.Sub(rl.nowFunc())
+	hl.waiting++
+	rl.mu.Unlock()
+
+	defer func() {
+		rl.mu.Lock()
+		hl.waiting--
+		rl.mu.Unlock()
+	}()
+
+	if estimate >= waitNotifyThreshold {
+		rl.logger.Info("request delayed",
+			zap.String("group", string(group)),
+			zap.Duration("estimate", estimate))
+
+		if fn := waitNotify(ctx); fn != nil {
+			fn(estimate)
+		}
+	}
+
+	if pause > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+
+	permits := hl.permit()
+
+	if permits == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-permits:
+	}
+
+	return nil
+}
+
+// Update pauses the host group until x-rate-limit-reset if the response says no requests are remaining
+func (rl *RateLimiter) Update(rawurl string, header http.Header) {
+	group, ok := HostGroupOf(rawurl)
+	if !ok {
+		return
+	}
+
+	remaining, err := strconv.Atoi(header.Get("x-rate-limit-remaining"))
+	if err != nil || remaining > 0 {
+		return
+	}
+
+	reset, err := strconv.ParseInt(header.Get("x-rate-limit-reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	resetTime := time.Unix(reset, 0)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	hl := rl.hosts[group]
+
+	if resetTime.After(hl.pausedUntil) {
+		rl.logger.Warn("rate limit exhausted",
+			zap.String("group", string(group)),
+			zap.Time("reset", resetTime))
+
+		hl.pausedUntil = resetTime
+	}
+}
+
+// Apply installs the limiter into the resty client middlewares
+func (rl *RateLimiter) Apply(r *resty.Client) *resty.Client {
+	r.OnBeforeRequest(func(c *resty.Client, req *resty.Request) error {
+		return rl.Wait(req.Context(), req.URL)
+	})
+
+	r.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		rl.Update(resp.Request.URL, resp.Header())
+		return nil
+	})
+
+	return r
+}
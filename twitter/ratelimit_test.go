@@ -0,0 +1,66 @@
+package twitter
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostGroupOf(t *testing.T) {
+	cases := map[string]HostGroup{
+		"https://x.com/contextdogs/status/1742878545549087076":                       HostGroupToken,
+		"https://abs.twimg.com/responsive-web/client-web-legacy/main.3ba1b53a.js":    HostGroupToken,
+		"https://api.twitter.com/graphql/7xflPyRiUxGVbJd4uWmbfg/TweetResultByRestId": HostGroupGraphQL,
+		"https://pbs.twimg.com/media/GM1.jpg":                                        HostGroupMedia,
+		"https://video.twimg.com/ext_tw_video/1/pu/vid/avc1/720x1280/a.mp4":          HostGroupMedia,
+	}
+
+	for u, expected := range cases {
+		group, ok := HostGroupOf(u)
+		require.True(t, ok, u)
+		require.Equal(t, expected, group, u)
+	}
+
+	_, ok := HostGroupOf("https://google.com")
+	require.False(t, ok)
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	rl := NewRateLimiter(RateLimits{})
+	rl.nowFunc = func() time.Time { return now }
+
+	url := "https://api.twitter.com/graphql/7xflPyRiUxGVbJd4uWmbfg/TweetResultByRestId"
+
+	header := http.Header{}
+	header.Set("x-rate-limit-remaining", "5")
+	header.Set("x-rate-limit-reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+
+	rl.Update(url, header)
+	require.Equal(t, time.Duration(0), rl.Estimate(url))
+
+	header.Set("x-rate-limit-remaining", "0")
+
+	rl.Update(url, header)
+	require.Equal(t, time.Minute, rl.Estimate(url))
+	require.Equal(t, time.Duration(0), rl.Estimate("https://pbs.twimg.com/media/GM1.jpg"))
+}
+
+func TestRateLimiterWaitCancel(t *testing.T) {
+	rl := NewRateLimiter(RateLimits{GraphQL: 1})
+
+	url := "https://api.twitter.com/graphql/7xflPyRiUxGVbJd4uWmbfg/TweetResultByRestId"
+
+	require.NoError(t, rl.Wait(context.Background(), url))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.Error(t, rl.Wait(ctx, url))
+	require.Equal(t, time.Duration(0), rl.Estimate(url))
+}
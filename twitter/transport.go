@@ -0,0 +1,157 @@
+package twitter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// TransportOptions tune the http transport shared by the clients talking to X
+type TransportOptions struct {
+	// connections to a host including the ones in use. Zero means no limit
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// resolved addresses are reused for this long. Zero disables the cache
+	DNSCacheTTL time.Duration
+}
+
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxConnsPerHost:     8,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		DNSCacheTTL:         5 * time.Minute,
+	}
+}
+
+// NewTransport returns a keep-alive http/2 transport with the connection limits and a dns cache
+func NewTransport(opts TransportOptions) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	dial := dialer.DialContext
+
+	if opts.DNSCacheTTL > 0 {
+		dial = newDNSCache(net.DefaultResolver, opts.DNSCacheTTL).dialer(dialer)
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+var sharedTransport = sync.OnceValue(func() *http.Transport {
+	return NewTransport(DefaultTransportOptions())
+})
+
+// SharedTransport is used by the clients unless another transport is set
+func SharedTransport() *http.Transport {
+	return sharedTransport()
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// caches the addresses of the hosts. Only successful lookups are cached
+type dnsCache struct {
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	ttl        time.Duration
+	nowFunc    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+func newDNSCache(resolver *net.Resolver, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		lookupHost: resolver.LookupHost,
+		ttl:        ttl,
+		nowFunc:    time.Now,
+		entries:    make(map[string]dnsEntry),
+	}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+
+	if ok && c.nowFunc().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := c.lookupHost(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: c.nowFunc().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return addrs, nil
+}
+
+func (c *dnsCache) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host)
+}
+
+// dials the cached addresses in order until one connects
+func (c *dnsCache) dialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var dialErr error
+
+		for _, ip := range addrs {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+		}
+
+		if dialErr == nil {
+			dialErr = errors.Errorf("no addresses for %s", host)
+		}
+
+		// the host may have moved
+		c.forget(host)
+
+		return nil, dialErr
+	}
+}
@@ -0,0 +1,87 @@
+package twitter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	ip, port, err := net.SplitHostPort(u.Host)
+	require.NoError(t, err)
+
+	// a closed port refuses the connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, closedPort, err := net.SplitHostPort(closed.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	now := time.Unix(1700000000, 0)
+	lookups := 0
+
+	c := newDNSCache(net.DefaultResolver, time.Minute)
+	c.nowFunc = func() time.Time { return now }
+	c.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if host == "unknown.test" {
+			return nil, errors.New("no such host")
+		}
+		return []string{ip}, nil
+	}
+
+	dial := c.dialer(&net.Dialer{Timeout: time.Second})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(ctx, "tcp", net.JoinHostPort("x.com", port))
+		require.NoError(t, err)
+		conn.Close()
+	}
+	require.Equal(t, 1, lookups)
+
+	now = now.Add(time.Minute)
+
+	conn, err := dial(ctx, "tcp", net.JoinHostPort("x.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 2, lookups)
+
+	// failed lookups are not cached
+	for i := 0; i < 2; i++ {
+		_, err = dial(ctx, "tcp", net.JoinHostPort("unknown.test", port))
+		require.Error(t, err)
+	}
+	require.Equal(t, 4, lookups)
+
+	// a failed dial drops the cached addresses, the host may have moved.
+	// The first dial uses the cached ones
+	for i := 0; i < 2; i++ {
+		_, err = dial(ctx, "tcp", net.JoinHostPort("x.com", closedPort))
+		require.Error(t, err)
+	}
+	require.Equal(t, 5, lookups)
+
+	conn, err = dial(ctx, "tcp", net.JoinHostPort("x.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 6, lookups)
+
+	conn, err = dial(ctx, "tcp", net.JoinHostPort("x.com", port))
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 6, lookups)
+}
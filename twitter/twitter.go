@@ -51,18 +51,25 @@ type Twitter struct {
 	logger     *zap.Logger
 	httpClient *resty.Client
 	saveData   bool
+	breaker    *CircuitBreaker
+	cache      *Cache
 }
 
 type Options struct {
-	httpClient *resty.Client
-	retryCount int
-	saveData   bool
+	httpClient  *resty.Client
+	retryCount  int
+	saveData    bool
+	rateLimiter *RateLimiter
+	breaker     *CircuitBreaker
+	cache       *Cache
+	transport   http.RoundTripper
 }
 
 type Option func(*Options)
 
 func DefaultResty() *resty.Client {
 	r := resty.New()
+	r.SetTransport(SharedTransport())
 	r.SetHeader("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0")
 	return r
 }
@@ -85,6 +92,34 @@ func WithSaveData(sd bool) Option {
 	}
 }
 
+// WithRateLimiter paces the requests to X with the limiter
+func WithRateLimiter(rl *RateLimiter) Option {
+	return func(o *Options) {
+		o.rateLimiter = rl
+	}
+}
+
+// WithCircuitBreaker makes GetTwitterData fail fast with ErrUnavailable while the breaker is open
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(o *Options) {
+		o.breaker = cb
+	}
+}
+
+// WithTransport sets the transport of the http client, by default it's SharedTransport
+func WithTransport(t http.RoundTripper) Option {
+	return func(o *Options) {
+		o.transport = t
+	}
+}
+
+// WithCache makes GetTwitterData serve tweets from the cache
+func WithCache(c *Cache) Option {
+	return func(o *Options) {
+		o.cache = c
+	}
+}
+
 func NewTwitter(opts ...Option) *Twitter {
 
 	options := &Options{
@@ -98,11 +133,45 @@ func NewTwitter(opts ...Option) *Twitter {
 
 	options.httpClient.SetRetryCount(options.retryCount)
 
+	if options.transport != nil {
+		options.httpClient.SetTransport(options.transport)
+	}
+
+	if options.rateLimiter != nil {
+		options.rateLimiter.Apply(options.httpClient)
+	}
+
 	return &Twitter{
 		httpClient: options.httpClient,
 		logger:     logger.Named("twitter"),
 		saveData:   options.saveData,
+		breaker:    options.breaker,
+		cache:      options.cache,
+	}
+}
+
+// IsCached returns true if the tweet data can be returned without requests to X
+func (t *Twitter) IsCached(url string) bool {
+	if t.cache == nil {
+		return false
+	}
+
+	turl, err := ParseTwitterURL(url)
+
+	if err != nil {
+		return false
+	}
+
+	_, ok := t.cache.Get(turl.ID)
+	return ok
+}
+
+// Available returns false while the circuit breaker is open
+func (t *Twitter) Available() bool {
+	if t.breaker == nil {
+		return true
 	}
+	return t.breaker.Available()
 }
 
 type Tokens struct {
@@ -136,6 +205,10 @@ func (t *Twitter) GetTokens(ctx context.Context, url string) (Tokens, error) {
 		return res, errors.Wrap(err, "failed to get twitter url")
 	}
 
+	if resp.IsError() {
+		return res, errors.Wrap(newStatusError(resp), "failed to get twitter url")
+	}
+
 	if t.saveData {
 		if err := saveBody(resp, "samples/twitter.html"); err != nil {
 			return res, errors.Wrap(err, "failed to save twitter html")
@@ -170,6 +243,10 @@ func (t *Twitter) GetTokens(ctx context.Context, url string) (Tokens, error) {
 		return res, errors.Wrap(err, "failed to get main js url")
 	}
 
+	if resp.IsError() {
+		return res, errors.Wrap(newStatusError(resp), "failed to get main js url")
+	}
+
 	rexBearerToken := regexp.MustCompile(`Bearer ([a-zA-Z0-9%]+)`)
 
 	bearerMatches := rexBearerToken.FindAllStringSubmatch(string(resp.Body()), -1)
@@ -236,18 +313,44 @@ func (t *Twitter) GetURLJSON(ctx context.Context, posturl string) ([]byte, error
 		}
 	}
 
+	if resp.IsError() {
+		return nil, errors.Wrap(newStatusError(resp), "failed to get graphql url")
+	}
+
 	t.logger.Debug("response", zap.Any("status", resp.Status()), zap.String("body", string(resp.Body())))
 
 	return resp.Body(), nil
 }
 
 func (t *Twitter) GetTwitterData(ctx context.Context, url string) (*TweetData, error) {
-	p := TwitterParser{}
 	turl, err := ParseTwitterURL(url)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse twitter url")
 	}
-	body, err := t.GetURLJSON(ctx, url)
+
+	if t.cache == nil {
+		return t.fetchTwitterData(ctx, turl)
+	}
+
+	td, err := t.cache.Fetch(ctx, turl.ID, func(ctx context.Context) (*TweetData, error) {
+		return t.fetchTwitterData(ctx, turl)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the cached data is shared
+	res := *td
+	res.Url = turl
+
+	return &res, nil
+}
+
+func (t *Twitter) fetchTwitterData(ctx context.Context, turl TwitterURL) (*TweetData, error) {
+	p := TwitterParser{}
+	url := turl.String()
+	body, err := t.getURLJSON(ctx, url)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get url json")
 	}
@@ -259,3 +362,18 @@ func (t *Twitter) GetTwitterData(ctx context.Context, url string) (*TweetData, e
 	td.Url = turl
 	return &td, nil
 }
+
+func (t *Twitter) getURLJSON(ctx context.Context, url string) ([]byte, error) {
+	if t.breaker == nil {
+		return t.GetURLJSON(ctx, url)
+	}
+
+	var body []byte
+
+	err := t.breaker.Execute(ctx, func() (err error) {
+		body, err = t.GetURLJSON(ctx, url)
+		return err
+	})
+
+	return body, err
+}
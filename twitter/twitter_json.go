@@ -1,5 +1,7 @@
 package twitter
 
+import "time"
+
 type TwitterParser struct {
 	d TweetData
 }
@@ -11,6 +13,21 @@ func (tp *TwitterParser) Parse(a interface{}) TweetData {
 
 func (tp *TwitterParser) ParseMap(aMap map[string]interface{}) {
 
+	if q, ok := aMap["quoted_status_result"]; ok {
+		tp.parseQuoted(q)
+
+		// the rest of the map without the quoted tweet so its text is not taken
+		rest := make(map[string]interface{}, len(aMap)-1)
+		for k, v := range aMap {
+			if k != "quoted_status_result" {
+				rest[k] = v
+			}
+		}
+		aMap = rest
+	}
+
+	tryParseTweetInfo(aMap, &tp.d)
+
 	if vd, ok := tryParseVideo(aMap); ok {
 		tp.d.AddVideo(vd)
 		return
@@ -33,6 +50,61 @@ func (tp *TwitterParser) ParseMap(aMap map[string]interface{}) {
 	parseMap(tp, aMap)
 }
 
+// the quoted tweet goes to Quoted, its media is added to the tweet
+func (tp *TwitterParser) parseQuoted(a interface{}) {
+	qp := TwitterParser{}
+	quoted := qp.Parse(a)
+
+	for _, v := range quoted.Videos {
+		tp.d.AddVideo(v)
+	}
+
+	for _, p := range quoted.Photos {
+		tp.d.AddPhoto(p)
+	}
+
+	if !quoted.IsEmpty() && tp.d.Quoted == nil {
+		tp.d.Quoted = &quoted
+	}
+}
+
+// the author and the date from the tweet result like
+// {"core": {"user_results": {"result": {"core": {"name", "screen_name"}, "legacy": {...}}}}, "legacy": {"created_at"}}
+func tryParseTweetInfo(aMap map[string]interface{}, td *TweetData) {
+	if td.AuthorScreenName != "" {
+		return
+	}
+
+	core, ok := aMap["core"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	results, ok := core["user_results"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	user, ok := results["result"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	// the name moved from legacy to core of the user
+	for _, key := range []string{"core", "legacy"} {
+		if m, ok := user[key].(map[string]interface{}); ok && td.AuthorScreenName == "" {
+			td.AuthorName, _ = tryGetKeyString(m, "name")
+			td.AuthorScreenName, _ = tryGetKeyString(m, "screen_name")
+		}
+	}
+
+	if legacy, ok := aMap["legacy"].(map[string]interface{}); ok {
+		if createdAt, ok := tryGetKeyString(legacy, "created_at"); ok {
+			td.CreatedAt, _ = time.Parse(time.RubyDate, createdAt)
+		}
+	}
+}
+
 // parse video with variants
 func tryParseVideo(aMap map[string]interface{}) (Video, bool) {
 	res := Video{}
@@ -51,7 +123,16 @@ func tryParseVideo(aMap map[string]interface{}) (Video, bool) {
 		return res, false
 	}
 
+	res.PosterURL, _ = tryGetKeyString(aMap, "media_url_https")
+
+	if m, ok := aMap["original_info"].(map[string]interface{}); ok {
+		res.Width, _ = tryGetKeyInt(m, "width")
+		res.Height, _ = tryGetKeyInt(m, "height")
+	}
+
 	if m, ok := aMap["video_info"].(map[string]interface{}); ok {
+		res.DurationMillis, _ = tryGetKeyInt(m, "duration_millis")
+
 		vp := variantsParser{}
 		vp.ParseMap(m)
 		res.Variants = vp.variants
@@ -92,6 +173,10 @@ func tryParsePhotoData(aMap map[string]interface{}) (Photo, bool) {
 		}
 	}
 
+	if mediaKey, ok := tryGetKeyString(aMap, "media_key"); ok {
+		id.MediaKey = mediaKey
+	}
+
 	return id, true
 }
 
@@ -3,6 +3,7 @@ package twitter
 // testify
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -35,3 +36,86 @@ func TestParseUrl(t *testing.T) {
 	})
 
 }
+
+func TestVideoVariantResolution(t *testing.T) {
+	w, h, ok := VideoVariant{VideoURL: "https://video.twimg.com/ext_tw_video/1/pu/vid/avc1/720x1280/a.mp4?tag=12"}.Resolution()
+	require.True(t, ok)
+	require.Equal(t, 720, w)
+	require.Equal(t, 1280, h)
+
+	_, _, ok = VideoVariant{VideoURL: "https://video.twimg.com/ext_tw_video/1/pu/pl/a.m3u8"}.Resolution()
+	require.False(t, ok)
+}
+
+func TestSnowflakeTime(t *testing.T) {
+	ts, ok := SnowflakeTime("1742878545549087076")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC), ts.Truncate(time.Second))
+
+	_, ok = SnowflakeTime("abc")
+	require.False(t, ok)
+}
+
+func TestParseVideo(t *testing.T) {
+	data := `{"extended_entities": {"media": [{
+		"type": "video",
+		"media_key": "7_1",
+		"media_url_https": "https://pbs.twimg.com/ext_tw_video_thumb/1/pu/img/poster.jpg",
+		"original_info": {"width": 1920, "height": 1080},
+		"video_info": {
+			"duration_millis": 12345,
+			"variants": [
+				{"bitrate": 256000, "content_type": "video/mp4", "url": "https://video.twimg.com/vid/480x270/a.mp4"},
+				{"bitrate": 2176000, "content_type": "video/mp4", "url": "https://video.twimg.com/vid/1280x720/b.mp4"}
+			]
+		}
+	}]}}`
+
+	var jsonBody interface{}
+	require.NoError(t, JsonDecodeWithNumberString(data, &jsonBody))
+
+	p := TwitterParser{}
+	td := p.Parse(jsonBody)
+
+	require.Len(t, td.Videos, 1)
+
+	v := td.Videos[0]
+	require.Equal(t, "7_1", v.MediaKey)
+	require.Equal(t, 1920, v.Width)
+	require.Equal(t, 1080, v.Height)
+	require.Equal(t, 12345*time.Millisecond, v.Duration())
+	require.Equal(t, "https://pbs.twimg.com/ext_tw_video_thumb/1/pu/img/poster.jpg", v.PosterURL)
+	require.Len(t, v.Variants, 2)
+}
+
+func TestParseTweetInfo(t *testing.T) {
+	data := `{"data": {"tweetResult": {"result": {
+		"core": {"user_results": {"result": {"core": {"name": "Author", "screen_name": "author"}}}},
+		"legacy": {"created_at": "Thu Jan 04 12:00:01 +0000 2024", "full_text": "look at this"},
+		"quoted_status_result": {"result": {
+			"core": {"user_results": {"result": {"legacy": {"name": "Quoted", "screen_name": "quoted"}}}},
+			"legacy": {
+				"created_at": "Wed Jan 03 10:00:00 +0000 2024",
+				"full_text": "the quoted tweet",
+				"extended_entities": {"media": [{"type": "photo", "media_key": "3_1", "media_url_https": "https://pbs.twimg.com/media/a.jpg"}]}
+			}
+		}}
+	}}}}`
+
+	var jsonBody interface{}
+	require.NoError(t, JsonDecodeWithNumberString(data, &jsonBody))
+
+	p := TwitterParser{}
+	td := p.Parse(jsonBody)
+
+	require.Equal(t, "look at this", td.FullText)
+	require.Equal(t, "Author", td.AuthorName)
+	require.Equal(t, "author", td.AuthorScreenName)
+	require.Equal(t, time.Date(2024, 1, 4, 12, 0, 1, 0, time.UTC), td.CreatedAt.UTC())
+	require.Len(t, td.Photos, 1)
+
+	require.NotNil(t, td.Quoted)
+	require.Equal(t, "the quoted tweet", td.Quoted.FullText)
+	require.Equal(t, "quoted", td.Quoted.AuthorScreenName)
+	require.Nil(t, td.Quoted.Quoted)
+}